@@ -1,100 +1,263 @@
 package freesync
 
 import (
+	"math"
 	"sync"
 	"sync/atomic"
 
 	"github.com/wencan/freesync/lockfree"
+	"github.com/wencan/freesync/lockfree/epoch"
 )
 
 var deletedBagEntry = new(interface{})
 
+// minAutoCompactDeletions 自动压缩前，至少需要删除的次数。
+const minAutoCompactDeletions = 1024
+
+// autoCompactRetryDeletions 自动压缩没有释放空间时，再删除多少次后重试。
+const autoCompactRetryDeletions = 128
+
 // Bag 并发安全的容量。
 type Bag struct {
 	// mux 锁。
 	mu sync.Mutex
 
 	// store 实质存储数据。内部结构为*lockfree.Slice。
-	// slice增长时，需要加锁。
+	// slice增长和压缩时，需要加锁。
 	store atomic.Value
 
 	// indexPool 删除的索引，等待重用。
 	indexPool *lockfree.SinglyLinkedList
+
+	// domain 重用回收索引的Add和DeleteAt期间Pin住，压缩时据此等待还在使用旧结构的过程。
+	domain *epoch.Domain
+
+	// compactLimit 压缩期间，不小于它的回收索引暂不重用。不在压缩时为math.MaxInt64。
+	compactLimit atomic.Int64
+
+	// parked 压缩期间暂不重用的回收索引。压缩结束后，没有被释放的索引回到indexPool。
+	parked *lockfree.SinglyLinkedList
+
+	// compactedCapacity 压缩前的最大容量。压缩释放的索引都小于它，重复删除这些索引时忽略。
+	compactedCapacity atomic.Int64
+
+	// deletions 上次压缩后删除的次数。
+	deletions atomic.Int64
+
+	// autoCompact 是否自动压缩。默认关闭。
+	autoCompact atomic.Bool
 }
 
 // NewBag 新建一个Bag。
+// 默认不自动压缩，通过SetAutoCompact开启。
 func NewBag() *Bag {
 	bag := &Bag{
 		indexPool: lockfree.NewSinglyLinkedList(),
+		domain:    epoch.NewDomain(),
+		parked:    lockfree.NewSinglyLinkedList(),
 	}
+	bag.compactLimit.Store(math.MaxInt64)
 	return bag
 }
 
+// NewBagWithCapacity 新建一个预留了至少capacity容量的Bag。
+func NewBagWithCapacity(capacity int) *Bag {
+	bag := NewBag()
+	bag.Reserve(capacity)
+	return bag
+}
+
+// Reserve 预留容量，保证至少能存放capacity个元素，不再需要逐步增长。
+// 一次创建全部需要的空间。压缩可能释放末尾没有使用的预留容量。
+func (bag *Bag) Reserve(capacity int) {
+	bag.mu.Lock()
+	defer bag.mu.Unlock()
+
+	store, _ := bag.store.Load().(*lockfree.Slice)
+	if store == nil {
+		store = &lockfree.Slice{}
+		bag.store.Store(store)
+	}
+	store.GrowTo(capacity)
+}
+
+// SetAutoCompact 开启或关闭自动压缩。
+// 开启后，删除次数超过容量的一半时，DeleteAt尝试释放末尾全部已删除的空间。
+// 压缩在调用DeleteAt的过程中进行，要等待正在进行的Add和DeleteAt结束，这次DeleteAt的耗时会明显增加。
+func (bag *Bag) SetAutoCompact(enabled bool) {
+	bag.autoCompact.Store(enabled)
+}
+
 // Add 添加一个元素，返回索引。
 // 警告：删除元素的索引会被重用。
 func (bag *Bag) Add(p interface{}) int {
 	store, _ := bag.store.Load().(*lockfree.Slice)
 	if store != nil {
+		// Append不需要Pin住。压缩时封闭LimitedSlice，会等待已经开始的Append
 		if index, ok := store.Append(p); ok {
 			return index
 		}
 
-		// 尝试重用回收的索引
-		recycled, _ := bag.indexPool.LeftPop()
-		if recycled != nil {
-			// 拿到的可能时Grow之后的index
-			// 使用最新的lockfree.Slice
-			store, _ = bag.store.Load().(*lockfree.Slice)
-
-			index := recycled.(int)
-			store.UpdateAt(index, p)
+		guard := bag.domain.Pin()
+		index, ok := bag.addRecycled(p)
+		guard.Unpin()
+		if ok {
 			return index
 		}
+
+		// 增长无锁。增长被封闭时，正在压缩，加锁等待
+		for {
+			if _, added := store.Grow(); added == 0 {
+				break
+			}
+			if index, ok := store.Append(p); ok {
+				return index
+			}
+		}
 	}
 
 	bag.mu.Lock()
 	defer bag.mu.Unlock()
 
+	store, _ = bag.store.Load().(*lockfree.Slice)
 	if store == nil {
 		// 初始化
-		store, _ = bag.store.Load().(*lockfree.Slice)
-		if store == nil {
-			store = &lockfree.Slice{}
-			bag.store.Store(store)
+		store = &lockfree.Slice{}
+		bag.store.Store(store)
+	}
+	for {
+		if index, ok := store.Append(p); ok {
+			return index
 		}
-	} else {
-		previous := store
-		store = bag.store.Load().(*lockfree.Slice)
-		if store != previous {
-			if index, ok := store.Append(p); ok {
-				return index
-			}
+		// 持有锁时，最新的存储不会封闭增长
+		if _, added := store.Grow(); added == 0 {
+			panic("impossibility")
 		}
 	}
+}
 
-	// 增加容量后再append
-	newStore, _ := store.Grow()
-	index, ok := newStore.Append(p)
-	if !ok {
-		panic("impossibility")
-	}
-	bag.store.Store(newStore)
+// addRecycled 重用回收的索引添加元素。没有可以重用的索引时，返回false。
+// 调用者需要Pin住。
+func (bag *Bag) addRecycled(p interface{}) (int, bool) {
+	for {
+		recycled, _ := bag.indexPool.LeftPop()
+		if recycled == nil {
+			return 0, false
+		}
+		index := recycled.(int)
+		if int64(index) >= bag.compactLimit.Load() {
+			// 所在的空间可能正在被释放
+			bag.parked.RightPush(index)
+			continue
+		}
 
-	return index
+		// 拿到的可能是压缩之前的index
+		// 使用最新的lockfree.Slice
+		store, _ := bag.store.Load().(*lockfree.Slice)
+		store.UpdateAt(index, p)
+		return index, true
+	}
 }
 
 // DeleteAt 删除指定位置上的元素。
 // 警告：删除后，index会被回收重用。
 func (bag *Bag) DeleteAt(index int) {
-	store, _ := bag.store.Load().(*lockfree.Slice)
+	store, deletions := bag.deleteAt(index)
+
+	// 压缩需要等待Pin住的过程，必须在Unpin之后
+	if bag.autoCompact.Load() && deletions >= minAutoCompactDeletions && deletions > int64(store.Capacity()/2) {
+		if bag.mu.TryLock() {
+			if !bag.compact() {
+				// 末尾还有元素。再删除一批后重试，检查到末尾第一个有效元素就停止，开销很小
+				bag.deletions.Add(-autoCompactRetryDeletions)
+			}
+			bag.mu.Unlock()
+		}
+	}
+}
+
+// deleteAt 删除，返回使用的存储，和上次压缩后删除的次数。重复删除时，删除次数为0。
+// index无效时panic，panic前Unpin，不会阻止之后的压缩。
+func (bag *Bag) deleteAt(index int) (store *lockfree.Slice, deletions int64) {
+	guard := bag.domain.Pin()
+	defer guard.Unpin()
+
+	store, _ = bag.store.Load().(*lockfree.Slice)
 	if store == nil {
 		panic("empty bag")
 	}
+	if index >= store.Capacity() {
+		if int64(index) < bag.compactedCapacity.Load() {
+			// 重复删除，所在的空间已经被压缩释放
+			return store, 0
+		}
+		panic("index out of range")
+	}
 
 	old := store.UpdateAt(index, deletedBagEntry)
 	if old != deletedBagEntry {
 		bag.indexPool.RightPush(index)
+		deletions = bag.deletions.Add(1)
+	}
+	return store, deletions
+}
+
+// Compact 释放末尾全部已删除的空间，减少遍历的开销。
+// 有效元素的索引不会改变。
+func (bag *Bag) Compact() {
+	bag.mu.Lock()
+	defer bag.mu.Unlock()
+
+	bag.compact()
+}
+
+// compact 压缩。需要持有锁。
+// 过程：
+// 1. 封闭增长。找出末尾全部已删除的LimitedSlice，封闭它们，不再Append；它们的回收索引也暂不重用。
+// 2. 等待之前开始的Add都已结束，重新检查。期间可能有Add追加或者重用了其中的索引，这部分空间保留。
+// 3. 再等待之前开始的DeleteAt都已结束，替换为缩短的Slice，从回收索引中去掉被释放的索引。
+// 如果释放了空间，返回true。
+func (bag *Bag) compact() bool {
+	store, _ := bag.store.Load().(*lockfree.Slice)
+	if store == nil {
+		return false
+	}
+	newStore, compacted := store.CompactTail(func(p interface{}) bool {
+		return p == deletedBagEntry
+	}, func(startIndex int) {
+		bag.compactLimit.Store(int64(startIndex))
+		bag.domain.Synchronize()
+	})
+	if newStore == store {
+		return false
+	}
+	bag.compactLimit.Store(int64(newStore.Capacity()))
+	bag.domain.Synchronize()
+
+	if capacity := int64(store.Capacity()); capacity > bag.compactedCapacity.Load() {
+		bag.compactedCapacity.Store(capacity)
+	}
+	bag.store.Store(newStore)
+	bag.deletions.Store(0)
+
+	// 去掉被释放的索引
+	var recycled []int
+	for _, pool := range []*lockfree.SinglyLinkedList{bag.indexPool, bag.parked} {
+		for {
+			p, ok := pool.LeftPop()
+			if !ok {
+				break
+			}
+			if index := p.(int); index < newStore.Capacity() {
+				recycled = append(recycled, index)
+			}
+		}
+	}
+	for _, index := range recycled {
+		bag.indexPool.RightPush(index)
 	}
+	bag.compactLimit.Store(math.MaxInt64)
+	return compacted
 }
 
 // Range 基于索引顺序的遍历。
@@ -4,6 +4,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"testing"
+
+	"github.com/wencan/freesync/lockfree"
 )
 
 func BenchmarkBagAdd(b *testing.B) {
@@ -20,6 +22,20 @@ func BenchmarkBagAdd(b *testing.B) {
 	})
 }
 
+func BenchmarkShardedBagAdd(b *testing.B) {
+	bag := NewShardedBag[uint64](0)
+
+	var number uint64
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			i := atomic.AddUint64(&number, 1)
+			bag.Add(i)
+		}
+	})
+}
+
 func BenchmarkSyncMapAdd(b *testing.B) {
 	var mapping sync.Map
 
@@ -53,6 +69,21 @@ func BenchmarkMutexMapAdd(b *testing.B) {
 	})
 }
 
+func BenchmarkIntMapAdd(b *testing.B) {
+	mapping := lockfree.NewIntMap[uint64]()
+
+	var number uint64
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			i := atomic.AddUint64(&number, 1)
+
+			mapping.Store(i, i)
+		}
+	})
+}
+
 func BenchmarkBagWrite(b *testing.B) {
 	bag := NewBag()
 
@@ -120,6 +151,77 @@ func BenchmarkMutexMapWrite(b *testing.B) {
 	})
 }
 
+func BenchmarkIntMapWrite(b *testing.B) {
+	mapping := lockfree.NewIntMap[int]()
+
+	ch := make(chan int, 10000000)
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		var i int
+		for p.Next() {
+			mapping.Store(uint64(i), i)
+			ch <- i
+
+			i++
+
+			delI := <-ch
+			mapping.Delete(uint64(delI))
+		}
+	})
+}
+
+func BenchmarkIntMapLoad(b *testing.B) {
+	mapping := lockfree.NewIntMap[uint64]()
+	for i := uint64(0); i < 10000; i++ {
+		mapping.Store(i, i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		var i uint64
+		for p.Next() {
+			mapping.Load(i % 10000)
+			i++
+		}
+	})
+}
+
+func BenchmarkSyncMapLoad(b *testing.B) {
+	var mapping sync.Map
+	for i := uint64(0); i < 10000; i++ {
+		mapping.Store(i, i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		var i uint64
+		for p.Next() {
+			mapping.Load(i % 10000)
+			i++
+		}
+	})
+}
+
+func BenchmarkMutexMapLoad(b *testing.B) {
+	mapping := make(map[uint64]uint64)
+	var mu sync.Mutex
+	for i := uint64(0); i < 10000; i++ {
+		mapping[i] = i
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		var i uint64
+		for p.Next() {
+			mu.Lock()
+			_ = mapping[i%10000]
+			mu.Unlock()
+			i++
+		}
+	})
+}
+
 func BenchmarkBagRange(b *testing.B) {
 	bag := NewBag()
 
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/wencan/freesync/lockfree"
 )
 
 func TestBag(t *testing.T) {
@@ -181,3 +182,161 @@ func TestBagConcurrentlyUpdateAndRange(t *testing.T) {
 
 	assert.Equal(t, 0, bag.Length())
 }
+
+func TestBag_Compact(t *testing.T) {
+	bag := NewBag()
+
+	indexes := make([]int, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		indexes = append(indexes, bag.Add(i))
+	}
+	// 删除后面的大部分，留下前100个和中间的一个
+	for i, index := range indexes {
+		if i >= 100 && i != 5000 {
+			bag.DeleteAt(index)
+		}
+	}
+	capacity := bag.store.Load().(*lockfree.Slice).Capacity()
+
+	bag.Compact()
+	newCapacity := bag.store.Load().(*lockfree.Slice).Capacity()
+	assert.True(t, newCapacity < capacity, "capacity: %d -> %d", capacity, newCapacity)
+	assert.True(t, newCapacity > indexes[5000])
+
+	// 索引不变
+	assert.Equal(t, 101, bag.Length())
+	bag.Range(func(index int, p interface{}) (stopIteration bool) {
+		num := p.(int)
+		assert.Equal(t, indexes[num], index)
+		return false
+	})
+
+	// 重复删除被释放的索引，没有影响
+	bag.DeleteAt(indexes[9999])
+	// 从来没有分配过的索引
+	assert.Panics(t, func() { bag.DeleteAt(capacity) })
+
+	// 重用回收的索引，之后继续增长
+	seen := make(map[int]bool)
+	for i := 0; i < 20000; i++ {
+		index := bag.Add(i)
+		assert.False(t, seen[index])
+		seen[index] = true
+		assert.NotEqual(t, indexes[5000], index)
+	}
+	assert.Equal(t, 20101, bag.Length())
+
+	// 全部删除后压缩
+	bag.Range(func(index int, p interface{}) (stopIteration bool) {
+		bag.DeleteAt(index)
+		return false
+	})
+	bag.Compact()
+	assert.Equal(t, 0, bag.store.Load().(*lockfree.Slice).Capacity())
+	assert.Equal(t, 0, bag.Add(1))
+}
+
+func TestBag_AutoCompact(t *testing.T) {
+	bag := NewBag()
+	bag.SetAutoCompact(true)
+	indexes := make([]int, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		indexes = append(indexes, bag.Add(i))
+	}
+	// 从后往前删除，删除过半后开始释放末尾的空间
+	for i := len(indexes) - 1; i >= 0; i-- {
+		bag.DeleteAt(indexes[i])
+	}
+	assert.True(t, bag.store.Load().(*lockfree.Slice).Capacity() < 5000)
+	assert.Equal(t, 0, bag.Length())
+}
+
+func TestBag_ConcurrentlyCompact(t *testing.T) {
+	// 并发添加、删除、压缩，有效元素的索引不变，索引不会同时分配给两个元素
+	bag := NewBag()
+
+	var live sync.Map
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var mine []int
+			for j := 0; j < 5000; j++ {
+				value := i*5000 + j
+				index := bag.Add(value)
+				if previous, loaded := live.LoadOrStore(index, value); loaded {
+					t.Errorf("index %d is used by %d and %d", index, previous, value)
+				}
+				mine = append(mine, index)
+
+				// 突发的批量删除
+				if j%1000 == 999 {
+					for _, index := range mine {
+						live.Delete(index)
+						bag.DeleteAt(index)
+					}
+					mine = mine[:0]
+				}
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 100; i++ {
+			bag.Compact()
+		}
+	}()
+	wg.Wait()
+
+	var length int
+	bag.Range(func(index int, p interface{}) (stopIteration bool) {
+		value, ok := live.Load(index)
+		assert.True(t, ok)
+		assert.Equal(t, value, p)
+		length++
+		return false
+	})
+	live.Range(func(key, value any) bool {
+		length--
+		return true
+	})
+	assert.Equal(t, 0, length)
+}
+
+func TestBag_Reserve(t *testing.T) {
+	bag := NewBagWithCapacity(100000)
+	store := bag.store.Load()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 10000; j++ {
+				bag.Add(j)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Same(t, store, bag.store.Load())
+	assert.Equal(t, 100000, bag.Length())
+}
+
+func TestBag_DeleteAtInvalidIndex(t *testing.T) {
+	bag := NewBag()
+	assert.Panics(t, func() { bag.DeleteAt(0) })
+
+	index := bag.Add(1)
+	assert.Panics(t, func() { bag.DeleteAt(index + 1<<20) })
+
+	// panic后不影响压缩
+	bag.DeleteAt(index)
+	bag.Compact()
+	assert.Equal(t, 0, bag.Length())
+}
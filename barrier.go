@@ -0,0 +1,159 @@
+package freesync
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// barrierGeneration 屏障的一代。
+type barrierGeneration struct {
+	// arrived 已经到达的过程数。达到parties后不再变化。
+	arrived atomic.Int64
+
+	// done 全部到达、action执行完后关闭。
+	done chan struct{}
+}
+
+// Barrier 可循环使用的屏障。
+// 每一代等待parties个过程全部到达，由最后到达的过程执行action，然后一起放行，开始下一代。
+// 放行的过程可以立即再次Await，进入下一代，不会与还没返回的过程混淆。
+type Barrier struct {
+	parties int64
+	action  func()
+
+	// generation 当前的一代。最后到达的过程换成新的一代后，才放行旧的一代。
+	generation atomic.Pointer[barrierGeneration]
+}
+
+// NewBarrier 新建一个屏障。action可以为nil。
+func NewBarrier(parties int, action func()) *Barrier {
+	if parties <= 0 {
+		panic("parties must be positive")
+	}
+	barrier := &Barrier{
+		parties: int64(parties),
+		action:  action,
+	}
+	barrier.generation.Store(&barrierGeneration{done: make(chan struct{})})
+	return barrier
+}
+
+// Await 到达屏障，等待这一代的全部过程到达。
+// ctx结束时退出这一代，返回ctx.Err()，屏障依然等待parties个过程；
+// 如果退出前这一代已经全部到达，返回nil。
+// 最后到达的过程执行action，action panic时依然放行其它过程。
+func (barrier *Barrier) Await(ctx context.Context) error {
+	generation, arrived, err := barrier.arrive(ctx)
+	if err != nil {
+		return err
+	}
+	if arrived == barrier.parties {
+		defer func() {
+			barrier.generation.Store(&barrierGeneration{done: make(chan struct{})})
+			close(generation.done)
+		}()
+		if barrier.action != nil {
+			barrier.action()
+		}
+		return nil
+	}
+
+	select {
+	case <-generation.done:
+		return nil
+	case <-ctx.Done():
+		// 退出。这一代已经全部到达时，不能退出
+		for {
+			arrived := generation.arrived.Load()
+			if arrived == barrier.parties {
+				<-generation.done
+				return nil
+			}
+			if generation.arrived.CompareAndSwap(arrived, arrived-1) {
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// arrive 到达当前一代，返回这一代和到达的顺序。
+func (barrier *Barrier) arrive(ctx context.Context) (*barrierGeneration, int64, error) {
+	for {
+		generation := barrier.generation.Load()
+		arrived := generation.arrived.Load()
+		if arrived == barrier.parties {
+			// 这一代已满，正在执行action，等待换代
+			select {
+			case <-generation.done:
+				continue
+			case <-ctx.Done():
+				return nil, 0, ctx.Err()
+			}
+		}
+		if generation.arrived.CompareAndSwap(arrived, arrived+1) {
+			return generation, arrived + 1, nil
+		}
+	}
+}
+
+// Waiting 当前一代已经到达的过程数。
+func (barrier *Barrier) Waiting() int {
+	return int(barrier.generation.Load().arrived.Load())
+}
+
+// Latch 一次性的倒计数门闩。计数减到0后，全部Wait返回，之后的Wait也立即返回。
+type Latch struct {
+	count atomic.Int64
+
+	// done 计数减到0时关闭。
+	done chan struct{}
+}
+
+// NewLatch 新建一个计数为count的门闩。
+func NewLatch(count int) *Latch {
+	if count < 0 {
+		panic("count must be non-negative.")
+	}
+	latch := &Latch{done: make(chan struct{})}
+	latch.count.Store(int64(count))
+	if count == 0 {
+		close(latch.done)
+	}
+	return latch
+}
+
+// CountDown 计数减1。已经为0时，不变。
+func (latch *Latch) CountDown() {
+	for {
+		count := latch.count.Load()
+		if count == 0 {
+			return
+		}
+		if latch.count.CompareAndSwap(count, count-1) {
+			if count == 1 {
+				close(latch.done)
+			}
+			return
+		}
+	}
+}
+
+// Count 当前计数。
+func (latch *Latch) Count() int {
+	return int(latch.count.Load())
+}
+
+// Wait 等待计数减到0，或者ctx结束。
+func (latch *Latch) Wait(ctx context.Context) error {
+	select {
+	case <-latch.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done 计数减到0时关闭的通道。
+func (latch *Latch) Done() <-chan struct{} {
+	return latch.done
+}
@@ -0,0 +1,131 @@
+package freesync
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBarrier_Generations(t *testing.T) {
+	const parties = 8
+	const rounds = 2000
+
+	// 每一轮每个过程写入自己的位置，屏障之后检查全部过程都写入了这一轮
+	var round atomic.Int64
+	values := make([]atomic.Int64, parties)
+	barrier := NewBarrier(parties, func() {
+		for i := range values {
+			assert.Equal(t, round.Load(), values[i].Load())
+		}
+		round.Add(1)
+	})
+
+	// 第二个屏障，保证检查时没有过程已经写入下一轮
+	next := NewBarrier(parties, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < parties; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for r := int64(0); r < rounds; r++ {
+				values[i].Store(r)
+				if !assert.NoError(t, barrier.Await(context.Background())) {
+					return
+				}
+				// action执行完才放行
+				if !assert.Equal(t, r+1, round.Load()) {
+					return
+				}
+				next.Await(context.Background())
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int64(rounds), round.Load())
+}
+
+func TestBarrier_Cancel(t *testing.T) {
+	barrier := NewBarrier(2, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, barrier.Await(ctx))
+	assert.Equal(t, 0, barrier.Waiting())
+
+	// 退出后，屏障依然等待2个过程
+	errs := make(chan error)
+	go func() {
+		errs <- barrier.Await(context.Background())
+	}()
+	for barrier.Waiting() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.NoError(t, barrier.Await(context.Background()))
+	assert.NoError(t, <-errs)
+	assert.Equal(t, 0, barrier.Waiting())
+}
+
+func TestBarrier_ConcurrentlyCancel(t *testing.T) {
+	// 部分过程不断超时退出，不会多放行或者卡住
+	const parties = 4
+	var generations atomic.Int64
+	barrier := NewBarrier(parties, func() {
+		generations.Add(1)
+	})
+
+	var passed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 200; j++ {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Duration(i%4)*time.Millisecond)
+				if barrier.Await(ctx) == nil {
+					passed.Add(1)
+				}
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 每一代放行parties个过程，剩下的还在等待
+	assert.Equal(t, generations.Load()*parties, passed.Load())
+	assert.Equal(t, 0, barrier.Waiting())
+}
+
+func TestLatch(t *testing.T) {
+	latch := NewLatch(3)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			assert.NoError(t, latch.Wait(context.Background()))
+			assert.Equal(t, 0, latch.Count())
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, latch.Wait(ctx))
+
+	for i := 0; i < 5; i++ {
+		go latch.CountDown()
+	}
+	wg.Wait()
+	<-latch.Done()
+	assert.Equal(t, 0, latch.Count())
+
+	assert.NoError(t, NewLatch(0).Wait(context.Background()))
+}
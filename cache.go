@@ -0,0 +1,399 @@
+package freesync
+
+import (
+	"container/heap"
+	"container/list"
+	"math/bits"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EvictionReason 条目被淘汰的原因。
+type EvictionReason int
+
+const (
+	// EvictionCapacity 超出容量，被淘汰策略淘汰。
+	EvictionCapacity EvictionReason = iota
+
+	// EvictionExpired 过期。
+	EvictionExpired
+)
+
+// CacheOptions 缓存的配置。
+type CacheOptions[K comparable, V any] struct {
+	// Capacity 最大条目数。必须大于0。按分片精确划分，总条目数不会超过Capacity。
+	Capacity int
+
+	// Policy 淘汰策略。默认为LRU。
+	Policy CachePolicy
+
+	// TTL 默认的存活时间。为0表示不过期。
+	TTL time.Duration
+
+	// Shards 分片数。会向上取整到2的幂，但不超过Capacity。为0时根据GOMAXPROCS和容量自动选择。
+	Shards int
+
+	// OnEvict 条目因容量或过期被淘汰时调用。在锁外调用。
+	// 主动删除和覆盖不会调用。
+	OnEvict func(key K, value V, reason EvictionReason)
+
+	// Hasher 键的哈希函数。为nil时使用默认哈希函数。
+	Hasher Hasher[K]
+}
+
+// CacheStats 缓存的统计数据。
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// HitRatio 命中率。
+func (stats CacheStats) HitRatio() float64 {
+	total := stats.Hits + stats.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(stats.Hits) / float64(total)
+}
+
+// cacheEntry 缓存条目。key、value、hash、expireAt不会更新。
+type cacheEntry[K comparable, V any] struct {
+	key   K
+	value V
+	hash  uint64
+
+	// expireAt 过期时间，UnixNano。为0表示不过期。
+	expireAt int64
+
+	// 以下字段由分片锁保护，供淘汰策略使用。
+
+	// removed 已经从缓存中移除。读缓冲区中可能还残留它的访问记录。
+	removed bool
+
+	// element 在LRU链表中的位置。
+	element *list.Element
+
+	// segment W-TinyLFU中所在的段。
+	segment int
+
+	// frequency、tick、index LFU使用。
+	frequency int
+	tick      uint64
+	index     int
+
+	// expiryIndex 在过期堆中的位置。只有设置了过期时间的条目在堆中。
+	expiryIndex int
+}
+
+// cacheShard 缓存分片。
+type cacheShard[K comparable, V any] struct {
+	// data 条目。读无锁；修改都在mu内进行，保证与淘汰策略一致。
+	data *Ctrie[K, *cacheEntry[K, V]]
+
+	// mu 保护淘汰策略。
+	mu sync.Mutex
+
+	policy cachePolicy[K, V]
+
+	// expiries 设置了过期时间的条目，按过期时间排序。由mu保护。
+	expiries cacheExpiryHeap[K, V]
+
+	// readBuffer 读访问记录，在mu内批量交给淘汰策略。
+	readBuffer cacheReadBuffer[K, V]
+
+	// size 条目数量。
+	size atomic.Int64
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// Cache 分片的并发缓存。支持LRU、LFU、W-TinyLFU淘汰策略，和过期。
+// 读操作无锁：查找基于Ctrie，访问记录放入有损的环形缓冲区，由写操作或缓冲区满时批量处理。
+type Cache[K comparable, V any] struct {
+	shards []*cacheShard[K, V]
+
+	// shardShift 用哈希的高位选择分片。
+	shardShift uint
+
+	ttl     time.Duration
+	onEvict func(key K, value V, reason EvictionReason)
+	hasher  Hasher[K]
+
+	// now 当前时间。测试时可替换。
+	now func() time.Time
+}
+
+// NewCache 新建一个缓存。
+func NewCache[K comparable, V any](options CacheOptions[K, V]) *Cache[K, V] {
+	if options.Capacity <= 0 {
+		panic("capacity must be positive")
+	}
+
+	shards := options.Shards
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0) * 4
+		// 每个分片至少32个条目，否则淘汰过于不精确
+		for shards > 1 && options.Capacity/shards < 32 {
+			shards /= 2
+		}
+	}
+	shardBits := bits.Len(uint(shards - 1))
+	// 每个分片至少能放一个条目
+	for 1<<shardBits > options.Capacity {
+		shardBits--
+	}
+	shards = 1 << shardBits
+
+	hasher := options.Hasher
+	if hasher == nil {
+		hasher = defaultHasher[K]()
+	}
+
+	cache := &Cache[K, V]{
+		shards:     make([]*cacheShard[K, V], shards),
+		shardShift: uint(64 - shardBits),
+		ttl:        options.TTL,
+		onEvict:    options.OnEvict,
+		hasher:     hasher,
+		now:        time.Now,
+	}
+	for i := range cache.shards {
+		// 余数分给前面的分片，总容量恰好等于Capacity
+		shardCapacity := options.Capacity / shards
+		if i < options.Capacity%shards {
+			shardCapacity++
+		}
+		cache.shards[i] = &cacheShard[K, V]{
+			data:   NewCtrieWithHasher[K, *cacheEntry[K, V]](hasher),
+			policy: newCachePolicy[K, V](options.Policy, shardCapacity),
+		}
+	}
+	return cache
+}
+
+// shard 哈希所在的分片。
+func (cache *Cache[K, V]) shard(hash uint64) *cacheShard[K, V] {
+	if len(cache.shards) == 1 {
+		return cache.shards[0]
+	}
+	return cache.shards[hash>>cache.shardShift]
+}
+
+// expired 条目是否已过期。
+func (cache *Cache[K, V]) expired(entry *cacheEntry[K, V]) bool {
+	return entry.expireAt != 0 && cache.now().UnixNano() >= entry.expireAt
+}
+
+// Get 取得键对应的值。
+func (cache *Cache[K, V]) Get(key K) (value V, ok bool) {
+	shard := cache.shard(cache.hasher(key))
+	entry, ok := shard.data.Load(key)
+	if !ok {
+		shard.misses.Add(1)
+		return value, false
+	}
+
+	if cache.expired(entry) {
+		shard.misses.Add(1)
+		cache.expire(shard, entry)
+		return value, false
+	}
+
+	shard.hits.Add(1)
+	if full := shard.readBuffer.offer(entry); full && shard.mu.TryLock() {
+		shard.drainReadBuffer()
+		shard.mu.Unlock()
+	}
+	return entry.value, true
+}
+
+// Set 设置键对应的值。使用默认的存活时间。
+func (cache *Cache[K, V]) Set(key K, value V) {
+	cache.SetWithTTL(key, value, cache.ttl)
+}
+
+// SetWithTTL 设置键对应的值，指定存活时间。ttl为0表示不过期。
+func (cache *Cache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	hash := cache.hasher(key)
+	entry := &cacheEntry[K, V]{key: key, value: value, hash: hash}
+	if ttl > 0 {
+		entry.expireAt = cache.now().Add(ttl).UnixNano()
+	}
+
+	shard := cache.shard(hash)
+	shard.mu.Lock()
+	shard.drainReadBuffer()
+	// 先移除过期的条目。否则没人读取的过期条目一直占用容量，淘汰策略只能淘汰没过期的条目
+	expired := shard.removeExpired(cache.now().UnixNano())
+	if old, loaded := shard.data.Swap(key, entry); loaded {
+		shard.unlink(old)
+	} else {
+		shard.size.Add(1)
+	}
+	if entry.expireAt != 0 {
+		heap.Push(&shard.expiries, entry)
+	}
+	victims := shard.policy.add(entry)
+	for _, victim := range victims {
+		shard.data.Delete(victim.key)
+		shard.unlinkExpiry(victim)
+		victim.removed = true
+	}
+	shard.size.Add(-int64(len(victims)))
+	shard.evictions.Add(uint64(len(victims)))
+	shard.mu.Unlock()
+
+	if cache.onEvict != nil {
+		for _, entry := range expired {
+			cache.onEvict(entry.key, entry.value, EvictionExpired)
+		}
+		for _, victim := range victims {
+			cache.onEvict(victim.key, victim.value, EvictionCapacity)
+		}
+	}
+}
+
+// Delete 删除键。
+func (cache *Cache[K, V]) Delete(key K) {
+	shard := cache.shard(cache.hasher(key))
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.drainReadBuffer()
+	if old, loaded := shard.data.LoadAndDelete(key); loaded {
+		shard.unlink(old)
+		shard.size.Add(-1)
+	}
+}
+
+// expire 移除过期的条目。
+func (cache *Cache[K, V]) expire(shard *cacheShard[K, V], entry *cacheEntry[K, V]) {
+	shard.mu.Lock()
+	shard.drainReadBuffer()
+	current, ok := shard.data.Load(entry.key)
+	if !ok || current != entry {
+		// 已被覆盖或删除
+		shard.mu.Unlock()
+		return
+	}
+	shard.data.Delete(entry.key)
+	shard.unlink(entry)
+	shard.size.Add(-1)
+	shard.evictions.Add(1)
+	shard.mu.Unlock()
+
+	if cache.onEvict != nil {
+		cache.onEvict(entry.key, entry.value, EvictionExpired)
+	}
+}
+
+// Range 遍历未过期的条目。每个分片各自是一致的视图。
+func (cache *Cache[K, V]) Range(f func(key K, value V) (stopIteration bool)) {
+	var stop bool
+	for _, shard := range cache.shards {
+		if stop {
+			break
+		}
+		shard.data.Range(func(key K, entry *cacheEntry[K, V]) (stopIteration bool) {
+			if cache.expired(entry) {
+				return false
+			}
+			stop = f(key, entry.value)
+			return stop
+		})
+	}
+}
+
+// Length 长度。包括已过期但还未移除的条目。
+func (cache *Cache[K, V]) Length() int {
+	var length int64
+	for _, shard := range cache.shards {
+		length += shard.size.Load()
+	}
+	return int(length)
+}
+
+// Stats 统计数据。
+func (cache *Cache[K, V]) Stats() CacheStats {
+	var stats CacheStats
+	for _, shard := range cache.shards {
+		stats.Hits += shard.hits.Load()
+		stats.Misses += shard.misses.Load()
+		stats.Evictions += shard.evictions.Load()
+	}
+	return stats
+}
+
+// drainReadBuffer 把读访问记录交给淘汰策略。调用者必须持有分片锁。
+func (shard *cacheShard[K, V]) drainReadBuffer() {
+	shard.readBuffer.drain(func(entry *cacheEntry[K, V]) {
+		if !entry.removed {
+			shard.policy.access(entry)
+		}
+	})
+}
+
+// unlink 把已经从data中移除的条目从淘汰策略和过期堆中移除。调用者必须持有分片锁。
+func (shard *cacheShard[K, V]) unlink(entry *cacheEntry[K, V]) {
+	shard.policy.remove(entry)
+	shard.unlinkExpiry(entry)
+	entry.removed = true
+}
+
+// unlinkExpiry 把条目从过期堆中移除。调用者必须持有分片锁。
+func (shard *cacheShard[K, V]) unlinkExpiry(entry *cacheEntry[K, V]) {
+	if entry.expireAt != 0 {
+		heap.Remove(&shard.expiries, entry.expiryIndex)
+	}
+}
+
+// removeExpired 移除在now之前过期的条目，返回它们。调用者必须持有分片锁。
+func (shard *cacheShard[K, V]) removeExpired(now int64) (expired []*cacheEntry[K, V]) {
+	for len(shard.expiries) > 0 && shard.expiries[0].expireAt <= now {
+		entry := heap.Pop(&shard.expiries).(*cacheEntry[K, V])
+		shard.data.Delete(entry.key)
+		shard.policy.remove(entry)
+		entry.removed = true
+		expired = append(expired, entry)
+	}
+	shard.size.Add(-int64(len(expired)))
+	shard.evictions.Add(uint64(len(expired)))
+	return expired
+}
+
+// cacheExpiryHeap 按过期时间排序的最小堆。
+type cacheExpiryHeap[K comparable, V any] []*cacheEntry[K, V]
+
+func (h cacheExpiryHeap[K, V]) Len() int {
+	return len(h)
+}
+
+func (h cacheExpiryHeap[K, V]) Less(i, j int) bool {
+	return h[i].expireAt < h[j].expireAt
+}
+
+func (h cacheExpiryHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].expiryIndex = i
+	h[j].expiryIndex = j
+}
+
+func (h *cacheExpiryHeap[K, V]) Push(x interface{}) {
+	entry := x.(*cacheEntry[K, V])
+	entry.expiryIndex = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *cacheExpiryHeap[K, V]) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	entry.expiryIndex = -1
+	return entry
+}
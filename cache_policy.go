@@ -0,0 +1,306 @@
+package freesync
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// CachePolicy 缓存的淘汰策略。
+type CachePolicy int
+
+const (
+	// CachePolicyLRU 淘汰最久未被访问的条目。
+	CachePolicyLRU CachePolicy = iota
+
+	// CachePolicyLFU 淘汰访问次数最少的条目。次数相同时，淘汰最久未被访问的。
+	CachePolicyLFU
+
+	// CachePolicyTinyLFU W-TinyLFU。新条目先进入窗口LRU，
+	// 离开窗口时，与主区的淘汰候选比较访问频率，频率高的留下。
+	CachePolicyTinyLFU
+)
+
+// cachePolicy 淘汰策略的实现。所有方法都在分片锁内调用。
+type cachePolicy[K comparable, V any] interface {
+	// add 添加新条目，返回需要淘汰的条目。
+	add(entry *cacheEntry[K, V]) (victims []*cacheEntry[K, V])
+
+	// access 记录一次读访问。
+	access(entry *cacheEntry[K, V])
+
+	// remove 移除条目。
+	remove(entry *cacheEntry[K, V])
+}
+
+// newCachePolicy 新建指定容量的淘汰策略。
+func newCachePolicy[K comparable, V any](policy CachePolicy, capacity int) cachePolicy[K, V] {
+	switch policy {
+	case CachePolicyLRU:
+		return newLRUPolicy[K, V](capacity)
+	case CachePolicyLFU:
+		return &lfuPolicy[K, V]{capacity: capacity}
+	case CachePolicyTinyLFU:
+		return newTinyLFUPolicy[K, V](capacity)
+	default:
+		panic("unknown cache policy")
+	}
+}
+
+// lruPolicy LRU淘汰策略。
+type lruPolicy[K comparable, V any] struct {
+	capacity int
+
+	// entries 前面是最近访问的。
+	entries *list.List
+}
+
+func newLRUPolicy[K comparable, V any](capacity int) *lruPolicy[K, V] {
+	return &lruPolicy[K, V]{capacity: capacity, entries: list.New()}
+}
+
+func (policy *lruPolicy[K, V]) add(entry *cacheEntry[K, V]) (victims []*cacheEntry[K, V]) {
+	entry.element = policy.entries.PushFront(entry)
+	for policy.entries.Len() > policy.capacity {
+		victim := policy.entries.Back().Value.(*cacheEntry[K, V])
+		policy.remove(victim)
+		victims = append(victims, victim)
+	}
+	return victims
+}
+
+func (policy *lruPolicy[K, V]) access(entry *cacheEntry[K, V]) {
+	policy.entries.MoveToFront(entry.element)
+}
+
+func (policy *lruPolicy[K, V]) remove(entry *cacheEntry[K, V]) {
+	policy.entries.Remove(entry.element)
+	entry.element = nil
+}
+
+// lfuPolicy LFU淘汰策略。基于最小堆。
+type lfuPolicy[K comparable, V any] struct {
+	capacity int
+
+	entries lfuHeap[K, V]
+
+	// tick 访问时钟。访问次数相同时，淘汰tick最小的。
+	tick uint64
+}
+
+func (policy *lfuPolicy[K, V]) add(entry *cacheEntry[K, V]) (victims []*cacheEntry[K, V]) {
+	// 先淘汰再添加，否则新条目访问次数最少，总是被立即淘汰
+	for policy.entries.Len() >= policy.capacity {
+		victim := heap.Pop(&policy.entries).(*cacheEntry[K, V])
+		victims = append(victims, victim)
+	}
+
+	policy.tick++
+	entry.frequency = 1
+	entry.tick = policy.tick
+	heap.Push(&policy.entries, entry)
+	return victims
+}
+
+func (policy *lfuPolicy[K, V]) access(entry *cacheEntry[K, V]) {
+	policy.tick++
+	entry.frequency++
+	entry.tick = policy.tick
+	heap.Fix(&policy.entries, entry.index)
+}
+
+func (policy *lfuPolicy[K, V]) remove(entry *cacheEntry[K, V]) {
+	heap.Remove(&policy.entries, entry.index)
+}
+
+// lfuHeap 按访问次数和访问时钟排序的最小堆。
+type lfuHeap[K comparable, V any] []*cacheEntry[K, V]
+
+func (h lfuHeap[K, V]) Len() int {
+	return len(h)
+}
+
+func (h lfuHeap[K, V]) Less(i, j int) bool {
+	if h[i].frequency != h[j].frequency {
+		return h[i].frequency < h[j].frequency
+	}
+	return h[i].tick < h[j].tick
+}
+
+func (h lfuHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap[K, V]) Push(x interface{}) {
+	entry := x.(*cacheEntry[K, V])
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *lfuHeap[K, V]) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	entry.index = -1
+	return entry
+}
+
+const (
+	tinyLFUWindow = iota
+	tinyLFUProbation
+	tinyLFUProtected
+)
+
+// tinyLFUPolicy W-TinyLFU淘汰策略。
+// 窗口区是LRU，占总容量的1%；主区是分段LRU，其中受保护段占80%。
+type tinyLFUPolicy[K comparable, V any] struct {
+	sketch *countMinSketch
+
+	window       *list.List
+	windowCap    int
+	probation    *list.List
+	protected    *list.List
+	protectedCap int
+	mainCap      int
+}
+
+func newTinyLFUPolicy[K comparable, V any](capacity int) *tinyLFUPolicy[K, V] {
+	windowCap := capacity / 100
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	mainCap := capacity - windowCap
+	return &tinyLFUPolicy[K, V]{
+		sketch:       newCountMinSketch(capacity),
+		window:       list.New(),
+		windowCap:    windowCap,
+		probation:    list.New(),
+		protected:    list.New(),
+		protectedCap: mainCap * 8 / 10,
+		mainCap:      mainCap,
+	}
+}
+
+func (policy *tinyLFUPolicy[K, V]) add(entry *cacheEntry[K, V]) (victims []*cacheEntry[K, V]) {
+	policy.sketch.increment(entry.hash)
+	entry.segment = tinyLFUWindow
+	entry.element = policy.window.PushFront(entry)
+
+	for policy.window.Len() > policy.windowCap {
+		// 离开窗口的候选，进入试用段
+		candidate := policy.window.Back().Value.(*cacheEntry[K, V])
+		policy.window.Remove(candidate.element)
+		candidate.segment = tinyLFUProbation
+		candidate.element = policy.probation.PushFront(candidate)
+
+		if policy.probation.Len()+policy.protected.Len() <= policy.mainCap {
+			continue
+		}
+
+		// 候选与试用段的淘汰者比较频率
+		victim := policy.probation.Back().Value.(*cacheEntry[K, V])
+		if victim != candidate && policy.sketch.estimate(candidate.hash) <= policy.sketch.estimate(victim.hash) {
+			victim = candidate
+		}
+		policy.remove(victim)
+		victims = append(victims, victim)
+	}
+	return victims
+}
+
+func (policy *tinyLFUPolicy[K, V]) access(entry *cacheEntry[K, V]) {
+	policy.sketch.increment(entry.hash)
+
+	switch entry.segment {
+	case tinyLFUWindow:
+		policy.window.MoveToFront(entry.element)
+	case tinyLFUProbation:
+		// 晋升到受保护段
+		policy.probation.Remove(entry.element)
+		entry.segment = tinyLFUProtected
+		entry.element = policy.protected.PushFront(entry)
+		if policy.protected.Len() > policy.protectedCap {
+			demoted := policy.protected.Back().Value.(*cacheEntry[K, V])
+			policy.protected.Remove(demoted.element)
+			demoted.segment = tinyLFUProbation
+			demoted.element = policy.probation.PushFront(demoted)
+		}
+	case tinyLFUProtected:
+		policy.protected.MoveToFront(entry.element)
+	}
+}
+
+func (policy *tinyLFUPolicy[K, V]) remove(entry *cacheEntry[K, V]) {
+	switch entry.segment {
+	case tinyLFUWindow:
+		policy.window.Remove(entry.element)
+	case tinyLFUProbation:
+		policy.probation.Remove(entry.element)
+	case tinyLFUProtected:
+		policy.protected.Remove(entry.element)
+	}
+	entry.element = nil
+}
+
+// countMinSketchDepth 计数草图的行数。
+const countMinSketchDepth = 4
+
+// countMinSketch 估算访问频率的计数草图。计数上限15。
+// 计数总数达到容量的10倍时，所有计数减半，让旧的热点逐渐冷却。
+type countMinSketch struct {
+	rows      [countMinSketchDepth][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+func newCountMinSketch(capacity int) *countMinSketch {
+	width := 16
+	for width < capacity {
+		width *= 2
+	}
+	sketch := &countMinSketch{
+		mask:    uint64(width - 1),
+		resetAt: capacity * 10,
+	}
+	for i := range sketch.rows {
+		sketch.rows[i] = make([]uint8, width)
+	}
+	return sketch
+}
+
+// index 第row行的位置。
+func (sketch *countMinSketch) index(hash uint64, row int) uint64 {
+	return mixUint64(hash+uint64(row)*0x9e3779b97f4a7c15) & sketch.mask
+}
+
+func (sketch *countMinSketch) increment(hash uint64) {
+	for row := range sketch.rows {
+		index := sketch.index(hash, row)
+		if sketch.rows[row][index] < 15 {
+			sketch.rows[row][index]++
+		}
+	}
+
+	sketch.additions++
+	if sketch.additions >= sketch.resetAt {
+		for row := range sketch.rows {
+			for index := range sketch.rows[row] {
+				sketch.rows[row][index] /= 2
+			}
+		}
+		sketch.additions /= 2
+	}
+}
+
+func (sketch *countMinSketch) estimate(hash uint64) uint8 {
+	estimate := uint8(15)
+	for row := range sketch.rows {
+		if count := sketch.rows[row][sketch.index(hash, row)]; count < estimate {
+			estimate = count
+		}
+	}
+	return estimate
+}
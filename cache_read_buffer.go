@@ -0,0 +1,52 @@
+package freesync
+
+import (
+	"sync/atomic"
+)
+
+// cacheReadBufferSize 读缓冲区的容量。必须是2的幂。
+const cacheReadBufferSize = 64
+
+// cacheReadBuffer 记录读访问的有损环形缓冲区。多生产者，单消费者。
+// 读操作只需要把条目放入缓冲区，不需要拿锁；缓冲区满时直接丢弃。
+// 持有分片锁的过程负责消费缓冲区，把读访问交给淘汰策略。
+type cacheReadBuffer[K comparable, V any] struct {
+	// head 下次消费的位置。只有持有分片锁的过程修改。
+	head atomic.Uint64
+
+	// tail 下次写入的位置。
+	tail atomic.Uint64
+
+	slots [cacheReadBufferSize]atomic.Pointer[cacheEntry[K, V]]
+}
+
+// offer 记录一次读访问。返回缓冲区是否已满，满了需要消费。
+func (buffer *cacheReadBuffer[K, V]) offer(entry *cacheEntry[K, V]) (full bool) {
+	for {
+		head := buffer.head.Load()
+		tail := buffer.tail.Load()
+		if tail-head >= cacheReadBufferSize {
+			// 有损：丢弃本次记录
+			return true
+		}
+		if buffer.tail.CompareAndSwap(tail, tail+1) {
+			buffer.slots[tail&(cacheReadBufferSize-1)].Store(entry)
+			return tail+1-head >= cacheReadBufferSize/2
+		}
+	}
+}
+
+// drain 消费缓冲区。调用者必须持有分片锁。
+func (buffer *cacheReadBuffer[K, V]) drain(f func(entry *cacheEntry[K, V])) {
+	head := buffer.head.Load()
+	tail := buffer.tail.Load()
+	for ; head < tail; head++ {
+		entry := buffer.slots[head&(cacheReadBufferSize-1)].Swap(nil)
+		if entry == nil {
+			// tail增长了，但条目还没存进去。下次再消费。
+			break
+		}
+		f(entry)
+	}
+	buffer.head.Store(head)
+}
@@ -0,0 +1,248 @@
+package freesync
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache_LRU(t *testing.T) {
+	var evicted []int
+	cache := NewCache(CacheOptions[int, int]{
+		Capacity: 3,
+		Shards:   1,
+		Policy:   CachePolicyLRU,
+		OnEvict: func(key, value int, reason EvictionReason) {
+			assert.Equal(t, EvictionCapacity, reason)
+			evicted = append(evicted, key)
+		},
+	})
+
+	cache.Set(1, 1)
+	cache.Set(2, 2)
+	cache.Set(3, 3)
+	cache.Get(1) // 1变成最近访问
+	cache.Set(4, 4)
+	assert.Equal(t, []int{2}, evicted)
+
+	_, ok := cache.Get(2)
+	assert.False(t, ok)
+	value, ok := cache.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	assert.Equal(t, 3, cache.Length())
+
+	// 覆盖不会淘汰
+	cache.Set(4, 40)
+	assert.Equal(t, []int{2}, evicted)
+	value, _ = cache.Get(4)
+	assert.Equal(t, 40, value)
+
+	cache.Delete(4)
+	assert.Equal(t, 2, cache.Length())
+
+	stats := cache.Stats()
+	assert.Equal(t, uint64(3), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.Equal(t, 0.75, stats.HitRatio())
+}
+
+func TestCache_LFU(t *testing.T) {
+	cache := NewCache(CacheOptions[int, int]{
+		Capacity: 3,
+		Shards:   1,
+		Policy:   CachePolicyLFU,
+	})
+
+	cache.Set(1, 1)
+	cache.Set(2, 2)
+	cache.Set(3, 3)
+	for i := 0; i < 3; i++ {
+		cache.Get(1)
+		cache.Get(3)
+	}
+	cache.Get(2)
+	cache.Set(4, 4) // 2访问次数最少
+	_, ok := cache.Get(2)
+	assert.False(t, ok)
+
+	cache.Set(5, 5) // 4访问次数最少
+	_, ok = cache.Get(4)
+	assert.False(t, ok)
+	for _, key := range []int{1, 3, 5} {
+		_, ok := cache.Get(key)
+		assert.True(t, ok)
+	}
+}
+
+func TestCache_TinyLFU(t *testing.T) {
+	capacity := 1000
+	cache := NewCache(CacheOptions[int, int]{
+		Capacity: capacity,
+		Shards:   1,
+		Policy:   CachePolicyTinyLFU,
+	})
+
+	// 热点数据
+	for round := 0; round < 5; round++ {
+		for key := 0; key < capacity/2; key++ {
+			if _, ok := cache.Get(key); !ok {
+				cache.Set(key, key)
+			}
+		}
+	}
+
+	// 一次性扫描不应该冲掉热点数据
+	for key := capacity; key < capacity*10; key++ {
+		cache.Set(key, key)
+	}
+
+	var hits int
+	for key := 0; key < capacity/2; key++ {
+		if _, ok := cache.Get(key); ok {
+			hits++
+		}
+	}
+	assert.True(t, hits > capacity/2*9/10, "hits: %d", hits)
+	assert.Equal(t, capacity, cache.Length())
+}
+
+func TestCache_TTL(t *testing.T) {
+	now := time.Now()
+	var evicted []string
+	cache := NewCache(CacheOptions[string, int]{
+		Capacity: 10,
+		TTL:      time.Minute,
+		OnEvict: func(key string, value int, reason EvictionReason) {
+			assert.Equal(t, EvictionExpired, reason)
+			evicted = append(evicted, key)
+		},
+	})
+	cache.now = func() time.Time { return now }
+
+	cache.Set("a", 1)
+	cache.SetWithTTL("b", 2, time.Hour)
+	cache.SetWithTTL("c", 3, 0)
+
+	now = now.Add(time.Minute * 2)
+	_, ok := cache.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"a"}, evicted)
+
+	_, ok = cache.Get("b")
+	assert.True(t, ok)
+
+	now = now.Add(time.Hour * 24)
+	var keys []string
+	cache.Range(func(key string, value int) (stopIteration bool) {
+		keys = append(keys, key)
+		return false
+	})
+	assert.Equal(t, []string{"c"}, keys)
+}
+
+func TestCache_ConcurrentlyGetSet(t *testing.T) {
+	for _, policy := range []CachePolicy{CachePolicyLRU, CachePolicyLFU, CachePolicyTinyLFU} {
+		capacity := 1000
+		cache := NewCache(CacheOptions[int, int]{
+			Capacity: capacity,
+			Shards:   4,
+			Policy:   policy,
+		})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				r := rand.New(rand.NewSource(time.Now().UnixNano()))
+				for j := 0; j < 10000; j++ {
+					key := r.Intn(capacity * 2)
+					if value, ok := cache.Get(key); ok {
+						assert.Equal(t, key, value)
+					} else {
+						cache.Set(key, key)
+					}
+					if j%100 == 0 {
+						cache.Delete(key)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.True(t, cache.Length() <= capacity)
+		var length int
+		cache.Range(func(key, value int) (stopIteration bool) {
+			length++
+			return false
+		})
+		assert.Equal(t, cache.Length(), length)
+
+		stats := cache.Stats()
+		assert.Equal(t, uint64(20*10000), stats.Hits+stats.Misses)
+	}
+}
+
+func TestCache_ExpiredBeforeCapacity(t *testing.T) {
+	now := time.Now()
+	reasons := map[string]EvictionReason{}
+	cache := NewCache(CacheOptions[string, int]{
+		Capacity: 3,
+		Shards:   1,
+		OnEvict: func(key string, value int, reason EvictionReason) {
+			reasons[key] = reason
+		},
+	})
+	cache.now = func() time.Time { return now }
+
+	cache.SetWithTTL("a", 1, time.Minute)
+	cache.Set("b", 2)
+	cache.Set("c", 3)
+
+	// 没人读取的过期条目先被移除，不挤掉没过期的条目
+	now = now.Add(time.Minute * 2)
+	cache.Set("d", 4)
+	assert.Equal(t, map[string]EvictionReason{"a": EvictionExpired}, reasons)
+	assert.Equal(t, 3, cache.Length())
+	for _, key := range []string{"b", "c", "d"} {
+		_, ok := cache.Get(key)
+		assert.True(t, ok, key)
+	}
+
+	// 之后按容量淘汰
+	cache.Set("e", 5)
+	assert.Equal(t, EvictionCapacity, reasons["b"])
+
+	// 覆盖和删除设置了过期时间的条目
+	cache.SetWithTTL("e", 6, time.Minute)
+	cache.SetWithTTL("e", 7, time.Hour)
+	cache.Delete("e")
+	now = now.Add(time.Hour * 2)
+	cache.Set("f", 8)
+	_, ok := cache.Get("e")
+	assert.False(t, ok)
+}
+
+func TestCache_Capacity(t *testing.T) {
+	// 容量不能被分片数整除时，总条目数也不超过容量
+	for _, shards := range []int{1, 4, 16, 64} {
+		cache := NewCache(CacheOptions[int, int]{
+			Capacity: 37,
+			Shards:   shards,
+		})
+		for key := 0; key < 10000; key++ {
+			cache.Set(key, key)
+		}
+		assert.LessOrEqual(t, cache.Length(), 37, shards)
+	}
+
+	// 分片数不超过容量
+	cache := NewCache(CacheOptions[int, int]{Capacity: 3, Shards: 8})
+	assert.Len(t, cache.shards, 2)
+}
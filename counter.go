@@ -0,0 +1,187 @@
+package freesync
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/wencan/freesync/internal/cpu"
+)
+
+// counterCell 计数分片。独占缓存行。
+type counterCell struct {
+	value atomic.Int64
+	_     [cpu.CacheLineSize - 8]byte
+}
+
+// Counter 分片的计数器。零值可用。
+// 没有竞争时只更新一个原子变量；出现竞争后，每个过程更新自己所在处理器对应的分片，互不竞争。
+// 适合频繁更新、偶尔读取的场景。
+type Counter struct {
+	// base 没有竞争时的计数。
+	base atomic.Int64
+
+	// cells 出现竞争后创建的分片。
+	cells atomic.Pointer[[]counterCell]
+
+	hint shardHint
+
+	// mu 保证Sum、Reset之间没有并发。Add不受影响。
+	mu sync.Mutex
+}
+
+// Add 增加delta。不等待，不会被Sum、Reset阻塞。
+func (counter *Counter) Add(delta int64) {
+	cells := counter.cells.Load()
+	if cells == nil {
+		old := counter.base.Load()
+		if counter.base.CompareAndSwap(old, old+delta) {
+			return
+		}
+		// 出现竞争，启用分片
+		newCells := make([]counterCell, runtime.GOMAXPROCS(0))
+		counter.cells.CompareAndSwap(nil, &newCells)
+		cells = counter.cells.Load()
+	}
+	(*cells)[counter.hint.shard(len(*cells))].value.Add(delta)
+}
+
+// Inc 加1。
+func (counter *Counter) Inc() {
+	counter.Add(1)
+}
+
+// collect 累计全部分片。swap为true时，同时清零。
+func (counter *Counter) collect(swap bool) int64 {
+	read := (*atomic.Int64).Load
+	if swap {
+		read = func(value *atomic.Int64) int64 {
+			return value.Swap(0)
+		}
+	}
+
+	total := read(&counter.base)
+	if cells := counter.cells.Load(); cells != nil {
+		for i := range *cells {
+			total += read(&(*cells)[i].value)
+		}
+	}
+	return total
+}
+
+// Load 近似值。不等待，开销小。
+// 与Add并发时，结果可能不对应任何一个时刻：可能计入了后开始的Add，却漏掉了先完成的Add。
+func (counter *Counter) Load() int64 {
+	return counter.collect(false)
+}
+
+// Sum 累计值。
+// 同LongAdder.sum，不是原子快照：没有并发的Add时是精确值；Sum开始前完成的Add都会计入，Sum期间的Add可能计入，也可能不计入。
+// 与Load不同，Sum与Reset互斥，不会只读到Reset清零了一部分分片的结果。
+func (counter *Counter) Sum() int64 {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	return counter.collect(false)
+}
+
+// Reset 逐个分片清零，返回清零前的累计值。
+// 每个Add要么计入返回值，要么留在清零后的计数中，不会丢失。没有并发的Add时，返回的是精确值。
+func (counter *Counter) Reset() int64 {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	return counter.collect(true)
+}
+
+// MaxGauge 分片的最大值记录。零值可用。
+// 每个过程更新自己所在处理器对应的分片。只有新值更大时才写入，已经达到的最大值只需读取。
+type MaxGauge struct {
+	// cells 第一次Update时创建的分片。
+	cells atomic.Pointer[[]counterCell]
+
+	hint shardHint
+}
+
+// NewMaxGauge 新建一个最大值记录。
+func NewMaxGauge() *MaxGauge {
+	return &MaxGauge{}
+}
+
+// loadCells 分片。还没有创建时，创建。
+func (gauge *MaxGauge) loadCells() []counterCell {
+	if cells := gauge.cells.Load(); cells != nil {
+		return *cells
+	}
+	newCells := make([]counterCell, runtime.GOMAXPROCS(0))
+	for i := range newCells {
+		newCells[i].value.Store(math.MinInt64)
+	}
+	gauge.cells.CompareAndSwap(nil, &newCells)
+	return *gauge.cells.Load()
+}
+
+// Update 记录一个值。
+func (gauge *MaxGauge) Update(value int64) {
+	cells := gauge.loadCells()
+	cell := &cells[gauge.hint.shard(len(cells))].value
+	for {
+		old := cell.Load()
+		if value <= old || cell.CompareAndSwap(old, value) {
+			return
+		}
+	}
+}
+
+// Load 记录的最大值。没有记录时返回math.MinInt64。
+func (gauge *MaxGauge) Load() int64 {
+	max := int64(math.MinInt64)
+	if cells := gauge.cells.Load(); cells != nil {
+		for i := range *cells {
+			if value := (*cells)[i].value.Load(); value > max {
+				max = value
+			}
+		}
+	}
+	return max
+}
+
+// Reset 清除记录，返回清除前的最大值。不会丢失并发的Update。
+func (gauge *MaxGauge) Reset() int64 {
+	max := int64(math.MinInt64)
+	if cells := gauge.cells.Load(); cells != nil {
+		for i := range *cells {
+			if value := (*cells)[i].value.Swap(math.MinInt64); value > max {
+				max = value
+			}
+		}
+	}
+	return max
+}
+
+// MinGauge 分片的最小值记录。零值可用。
+type MinGauge struct {
+	// max 记录按位取反的值。按位取反是int64上的反序一一映射。
+	max MaxGauge
+}
+
+// NewMinGauge 新建一个最小值记录。
+func NewMinGauge() *MinGauge {
+	return &MinGauge{}
+}
+
+// Update 记录一个值。
+func (gauge *MinGauge) Update(value int64) {
+	gauge.max.Update(^value)
+}
+
+// Load 记录的最小值。没有记录时返回math.MaxInt64。
+func (gauge *MinGauge) Load() int64 {
+	return ^gauge.max.Load()
+}
+
+// Reset 清除记录，返回清除前的最小值。
+func (gauge *MinGauge) Reset() int64 {
+	return ^gauge.max.Reset()
+}
@@ -0,0 +1,57 @@
+package freesync
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func BenchmarkCounterInc(b *testing.B) {
+	var counter Counter
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			counter.Inc()
+		}
+	})
+}
+
+func BenchmarkAtomicInc(b *testing.B) {
+	var number uint64
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			atomic.AddUint64(&number, 1)
+		}
+	})
+}
+
+func BenchmarkMaxGaugeUpdate(b *testing.B) {
+	gauge := NewMaxGauge()
+	var number int64
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			gauge.Update(atomic.AddInt64(&number, 1) % 1024)
+		}
+	})
+}
+
+func BenchmarkAtomicMaxUpdate(b *testing.B) {
+	var max, number int64
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			value := atomic.AddInt64(&number, 1) % 1024
+			for {
+				old := atomic.LoadInt64(&max)
+				if value <= old || atomic.CompareAndSwapInt64(&max, old, value) {
+					break
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package freesync
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounter(t *testing.T) {
+	var counter Counter
+	assert.Equal(t, int64(0), counter.Sum())
+
+	counter.Inc()
+	counter.Add(10)
+	counter.Add(-3)
+	assert.Equal(t, int64(8), counter.Load())
+	assert.Equal(t, int64(8), counter.Sum())
+
+	assert.Equal(t, int64(8), counter.Reset())
+	assert.Equal(t, int64(0), counter.Sum())
+}
+
+func TestCounter_Concurrently(t *testing.T) {
+	var counter Counter
+
+	var wg sync.WaitGroup
+	var reset int64
+	var resetMu sync.Mutex
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 10000; j++ {
+				counter.Inc()
+				if i == 0 && j%1000 == 0 {
+					// 并发Reset不会丢失计数
+					resetMu.Lock()
+					reset += counter.Reset()
+					resetMu.Unlock()
+				}
+			}
+		}(i)
+	}
+
+	// 计数只增不减，Sum不会倒退
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var last int64
+		for i := 0; i < 1000; i++ {
+			resetMu.Lock()
+			sum := counter.Sum() + reset
+			resetMu.Unlock()
+			if !assert.True(t, sum >= last) {
+				return
+			}
+			last = sum
+		}
+	}()
+	wg.Wait()
+	<-done
+
+	assert.Equal(t, int64(50*10000), counter.Sum()+reset)
+	assert.Equal(t, int64(50*10000), counter.Load()+reset)
+}
+
+func TestCounter_AddDuringReset(t *testing.T) {
+	// Add不等待Sum、Reset
+	var counter Counter
+	counter.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		counter.Add(1)
+	}()
+	<-done
+	counter.mu.Unlock()
+	assert.Equal(t, int64(1), counter.Sum())
+}
+
+func TestMaxGauge(t *testing.T) {
+	gauge := NewMaxGauge()
+	assert.Equal(t, int64(math.MinInt64), gauge.Load())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j++ {
+				gauge.Update(int64(i*1000 + j))
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int64(9999), gauge.Load())
+
+	assert.Equal(t, int64(9999), gauge.Reset())
+	assert.Equal(t, int64(math.MinInt64), gauge.Load())
+	gauge.Update(-5)
+	assert.Equal(t, int64(-5), gauge.Load())
+}
+
+func TestMinGauge(t *testing.T) {
+	gauge := NewMinGauge()
+	assert.Equal(t, int64(math.MaxInt64), gauge.Load())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j++ {
+				gauge.Update(int64(i*1000 + j))
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int64(0), gauge.Load())
+
+	gauge.Update(math.MinInt64)
+	assert.Equal(t, int64(math.MinInt64), gauge.Reset())
+	assert.Equal(t, int64(math.MaxInt64), gauge.Load())
+}
+
+func TestGauge_ZeroValue(t *testing.T) {
+	var max MaxGauge
+	assert.Equal(t, int64(math.MinInt64), max.Load())
+	assert.Equal(t, int64(math.MinInt64), max.Reset())
+	max.Update(3)
+	max.Update(1)
+	assert.Equal(t, int64(3), max.Load())
+
+	var min MinGauge
+	assert.Equal(t, int64(math.MaxInt64), min.Load())
+	min.Update(3)
+	min.Update(1)
+	assert.Equal(t, int64(1), min.Load())
+}
@@ -0,0 +1,201 @@
+package freesync
+
+import (
+	"sync/atomic"
+)
+
+// COWSlice 写时复制的Slice。适合读多写少的场景。
+// 读只需要一次原子加载；写复制出新版本后，通过CAS替换根，冲突时重试。
+// 零值可用。
+type COWSlice[T any] struct {
+	// root 当前版本。版本一经发布，不再修改。
+	root atomic.Pointer[[]T]
+}
+
+// Load 返回当前版本。
+// 警告：返回的slice是共享的，不能修改。
+func (slice *COWSlice[T]) Load() []T {
+	root := slice.root.Load()
+	if root == nil {
+		return nil
+	}
+	return *root
+}
+
+// Store 替换为values的副本。
+func (slice *COWSlice[T]) Store(values []T) {
+	values = append([]T(nil), values...)
+	slice.root.Store(&values)
+}
+
+// Update 以旧版本为基础，生成并发布新版本。
+// f可能因冲突被调用多次，不能有副作用，也不能修改old。
+func (slice *COWSlice[T]) Update(f func(old []T) []T) {
+	for {
+		root := slice.root.Load()
+		var old []T
+		if root != nil {
+			old = *root
+		}
+		values := f(old)
+		if slice.root.CompareAndSwap(root, &values) {
+			return
+		}
+	}
+}
+
+// At 取得下标位置上的值。
+func (slice *COWSlice[T]) At(index int) T {
+	return slice.Load()[index]
+}
+
+// Append 在末尾追加元素。返回第一个追加元素的下标。
+func (slice *COWSlice[T]) Append(values ...T) int {
+	var index int
+	slice.Update(func(old []T) []T {
+		index = len(old)
+		return append(old[:len(old):len(old)], values...)
+	})
+	return index
+}
+
+// UpdateAt 更新下标位置上的值，返回旧值。
+func (slice *COWSlice[T]) UpdateAt(index int, p T) (old T) {
+	slice.Update(func(values []T) []T {
+		old = values[index]
+		copied := append([]T(nil), values...)
+		copied[index] = p
+		return copied
+	})
+	return old
+}
+
+// DeleteAt 删除下标位置上的值，后面的元素前移。返回被删除的值。
+func (slice *COWSlice[T]) DeleteAt(index int) (old T) {
+	slice.Update(func(values []T) []T {
+		old = values[index]
+		copied := make([]T, 0, len(values)-1)
+		copied = append(copied, values[:index]...)
+		return append(copied, values[index+1:]...)
+	})
+	return old
+}
+
+// Range 遍历。遍历的是调用时的版本。
+func (slice *COWSlice[T]) Range(f func(index int, p T) (stopIteration bool)) {
+	for index, p := range slice.Load() {
+		if f(index, p) {
+			break
+		}
+	}
+}
+
+// Length 长度。
+func (slice *COWSlice[T]) Length() int {
+	return len(slice.Load())
+}
+
+// COWMap 写时复制的Map。适合读多写少的场景，例如配置数据。
+// 读只需要一次原子加载；写复制出新版本后，通过CAS替换根，冲突时重试。
+// 零值可用。
+type COWMap[K comparable, V any] struct {
+	// root 当前版本。版本一经发布，不再修改。
+	root atomic.Pointer[map[K]V]
+}
+
+// current 返回当前版本。
+func (mapping *COWMap[K, V]) current() map[K]V {
+	root := mapping.root.Load()
+	if root == nil {
+		return nil
+	}
+	return *root
+}
+
+// Update 以旧版本为基础，生成并发布新版本。
+// f可能因冲突被调用多次，不能有副作用，也不能修改old。
+func (mapping *COWMap[K, V]) Update(f func(old map[K]V) map[K]V) {
+	for {
+		root := mapping.root.Load()
+		var old map[K]V
+		if root != nil {
+			old = *root
+		}
+		values := f(old)
+		if mapping.root.CompareAndSwap(root, &values) {
+			return
+		}
+	}
+}
+
+// Load 取得键对应的值。
+func (mapping *COWMap[K, V]) Load(key K) (value V, ok bool) {
+	value, ok = mapping.current()[key]
+	return value, ok
+}
+
+// Store 设置键对应的值。
+func (mapping *COWMap[K, V]) Store(key K, value V) {
+	mapping.Update(func(old map[K]V) map[K]V {
+		values := cloneMap(old, 1)
+		values[key] = value
+		return values
+	})
+}
+
+// LoadOrStore 如果键存在，返回已有的值，loaded为true；否则保存并返回value。
+func (mapping *COWMap[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	if actual, loaded = mapping.Load(key); loaded {
+		return actual, true
+	}
+
+	mapping.Update(func(old map[K]V) map[K]V {
+		if actual, loaded = old[key]; loaded {
+			return old
+		}
+		actual = value
+		values := cloneMap(old, 1)
+		values[key] = value
+		return values
+	})
+	return actual, loaded
+}
+
+// Delete 删除键。
+func (mapping *COWMap[K, V]) Delete(key K) {
+	if _, ok := mapping.Load(key); !ok {
+		return
+	}
+
+	mapping.Update(func(old map[K]V) map[K]V {
+		if _, ok := old[key]; !ok {
+			return old
+		}
+		values := cloneMap(old, 0)
+		delete(values, key)
+		return values
+	})
+}
+
+// Range 遍历。遍历的是调用时的版本。
+func (mapping *COWMap[K, V]) Range(f func(key K, value V) (stopIteration bool)) {
+	for key, value := range mapping.current() {
+		if f(key, value) {
+			break
+		}
+	}
+}
+
+// Length 长度。
+func (mapping *COWMap[K, V]) Length() int {
+	return len(mapping.current())
+}
+
+// cloneMap 复制map，预留extra个空位。
+func cloneMap[K comparable, V any](m map[K]V, extra int) map[K]V {
+	cloned := make(map[K]V, len(m)+extra)
+	for key, value := range m {
+		cloned[key] = value
+	}
+	return cloned
+}
@@ -0,0 +1,147 @@
+package freesync
+
+import (
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCOWSlice(t *testing.T) {
+	var slice COWSlice[int]
+	assert.Equal(t, 0, slice.Length())
+
+	for i := 0; i < 10; i++ {
+		index := slice.Append(i)
+		assert.Equal(t, i, index)
+	}
+	assert.Equal(t, 10, slice.Length())
+
+	// 旧版本不受后续修改影响
+	version := slice.Load()
+	old := slice.UpdateAt(0, 100)
+	assert.Equal(t, 0, old)
+	assert.Equal(t, 100, slice.At(0))
+	assert.Equal(t, 0, version[0])
+
+	old = slice.DeleteAt(0)
+	assert.Equal(t, 100, old)
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9}, slice.Load())
+
+	slice.Update(func(old []int) []int {
+		return old[:3:3]
+	})
+	assert.Equal(t, []int{1, 2, 3}, slice.Load())
+
+	values := []int{7, 8}
+	slice.Store(values)
+	values[0] = 0
+	assert.Equal(t, []int{7, 8}, slice.Load())
+}
+
+func TestCOWSlice_ConcurrentlyAppend(t *testing.T) {
+	var slice COWSlice[int]
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				index := slice.Append(i*100 + j)
+				// 读到的版本至少包含自己追加的元素
+				assert.True(t, slice.Length() > index)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	all := append([]int(nil), slice.Load()...)
+	sort.Ints(all)
+	want := make([]int, 0, 5000)
+	for i := 0; i < 5000; i++ {
+		want = append(want, i)
+	}
+	assert.Equal(t, want, all)
+}
+
+func TestCOWMap(t *testing.T) {
+	var mapping COWMap[string, int]
+
+	_, ok := mapping.Load("a")
+	assert.False(t, ok)
+
+	mapping.Store("a", 1)
+	mapping.Store("b", 2)
+	value, ok := mapping.Load("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+
+	actual, loaded := mapping.LoadOrStore("a", 10)
+	assert.True(t, loaded)
+	assert.Equal(t, 1, actual)
+	actual, loaded = mapping.LoadOrStore("c", 3)
+	assert.False(t, loaded)
+	assert.Equal(t, 3, actual)
+	assert.Equal(t, 3, mapping.Length())
+
+	mapping.Delete("b")
+	mapping.Delete("x")
+	assert.Equal(t, 2, mapping.Length())
+
+	mapping.Update(func(old map[string]int) map[string]int {
+		return map[string]int{"z": 26}
+	})
+	all := map[string]int{}
+	mapping.Range(func(key string, value int) (stopIteration bool) {
+		all[key] = value
+		return false
+	})
+	assert.Equal(t, map[string]int{"z": 26}, all)
+}
+
+func TestCOWMap_ConcurrentlyUpdate(t *testing.T) {
+	var mapping COWMap[int, int]
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				key := i*100 + j
+				mapping.Store(key, key)
+				if j%2 == 1 {
+					mapping.Delete(key)
+				}
+			}
+		}(i)
+	}
+
+	// 并发读
+	var wg2 sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg2.Add(1)
+		go func() {
+			defer wg2.Done()
+
+			for j := 0; j < 100; j++ {
+				mapping.Range(func(key, value int) (stopIteration bool) {
+					assert.Equal(t, key, value)
+					return false
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	wg2.Wait()
+
+	assert.Equal(t, 1000, mapping.Length())
+	mapping.Range(func(key, value int) (stopIteration bool) {
+		assert.Equal(t, 0, key%2)
+		return false
+	})
+}
@@ -0,0 +1,718 @@
+package freesync
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// 参考：Prokopec, Bronson, Bagwell, Odersky. Concurrent Tries with Efficient Non-Blocking Snapshots.
+
+const (
+	// ctrieLevelBits 每一层消耗的哈希位数。
+	ctrieLevelBits = 6
+
+	// ctrieLevelMask 每一层的哈希位掩码。
+	ctrieLevelMask = 1<<ctrieLevelBits - 1
+
+	// ctrieMaxLevel 哈希位耗尽的层级。到达后，冲突的键存放在lNode中。
+	ctrieMaxLevel = 64
+)
+
+// ctrieGen 代。快照通过切换根节点的代，让旧代的节点不再可写。
+// 只比较指针，不能是零大小的结构（零大小变量的地址可能相同）。
+type ctrieGen struct {
+	_ byte
+}
+
+// ctrieBranch cNode的分支，*ctrieINode或*ctrieSNode。
+type ctrieBranch[K comparable, V any] interface {
+	isCtrieBranch()
+}
+
+// ctrieINode 间接节点。唯一可变的节点，所有修改都是对main的GCAS。
+type ctrieINode[K comparable, V any] struct {
+	main atomic.Pointer[ctrieMainNode[K, V]]
+
+	// gen 创建时的代。
+	gen *ctrieGen
+}
+
+func (*ctrieINode[K, V]) isCtrieBranch() {}
+
+// ctrieSNode 存放一个键值对。不可变。
+type ctrieSNode[K comparable, V any] struct {
+	key   K
+	value V
+	hash  uint64
+}
+
+func (*ctrieSNode[K, V]) isCtrieBranch() {}
+
+// ctrieMainNode iNode指向的节点。cNode、tNode、lNode、failed只有一个不为nil。
+type ctrieMainNode[K comparable, V any] struct {
+	// cNode 分支节点。
+	cNode *ctrieCNode[K, V]
+
+	// tNode 墓碑节点。等待被清理压缩进父节点。
+	tNode *ctrieSNode[K, V]
+
+	// lNode 哈希完全冲突的键值对列表。
+	lNode *ctrieLNode[K, V]
+
+	// failed 失败节点。表示GCAS失败，需要回滚到failed。
+	failed *ctrieMainNode[K, V]
+
+	// prev GCAS过程中的旧值。为nil表示GCAS已提交。
+	prev atomic.Pointer[ctrieMainNode[K, V]]
+}
+
+// ctrieCNode 分支节点。不可变。
+type ctrieCNode[K comparable, V any] struct {
+	bitmap uint64
+	array  []ctrieBranch[K, V]
+	gen    *ctrieGen
+}
+
+// ctrieLNode 冲突列表。不可变。
+type ctrieLNode[K comparable, V any] struct {
+	entries []*ctrieSNode[K, V]
+}
+
+// ctrieRoot 根。iNode和desc只有一个不为nil。
+type ctrieRoot[K comparable, V any] struct {
+	iNode *ctrieINode[K, V]
+
+	// desc 进行中的RDCSS。
+	desc *ctrieDescriptor[K, V]
+}
+
+const (
+	ctrieUndecided int32 = iota
+	ctrieCommitted
+	ctrieAborted
+)
+
+// ctrieDescriptor RDCSS描述符。
+// 只有在old根节点的main仍然是expectedMain时，才能将根替换为new。
+type ctrieDescriptor[K comparable, V any] struct {
+	old          *ctrieRoot[K, V]
+	expectedMain *ctrieMainNode[K, V]
+	new          *ctrieRoot[K, V]
+
+	// state 先决定结果，再替换根。避免多个协助者做出不同的决定。
+	state atomic.Int32
+}
+
+// Ctrie 无锁的并发哈希字典树。
+// 支持常数时间的快照：快照后，原Ctrie和快照可以各自独立地修改。
+// 基于快照的Range和Length，得到的是某一时刻一致的视图。
+type Ctrie[K comparable, V any] struct {
+	root atomic.Pointer[ctrieRoot[K, V]]
+
+	// readOnly 只读快照。
+	readOnly bool
+
+	hasher Hasher[K]
+}
+
+// NewCtrie 新建一个Ctrie。使用默认的哈希函数。
+func NewCtrie[K comparable, V any]() *Ctrie[K, V] {
+	return NewCtrieWithHasher[K, V](defaultHasher[K]())
+}
+
+// NewCtrieWithHasher 新建一个使用指定哈希函数的Ctrie。
+func NewCtrieWithHasher[K comparable, V any](hasher Hasher[K]) *Ctrie[K, V] {
+	gen := &ctrieGen{}
+	in := &ctrieINode[K, V]{gen: gen}
+	in.main.Store(&ctrieMainNode[K, V]{cNode: &ctrieCNode[K, V]{gen: gen}})
+
+	ct := &Ctrie[K, V]{hasher: hasher}
+	ct.root.Store(&ctrieRoot[K, V]{iNode: in})
+	return ct
+}
+
+// Load 取得键对应的值。
+func (ct *Ctrie[K, V]) Load(key K) (value V, ok bool) {
+	hash := ct.hasher(key)
+	for {
+		root := ct.readRoot(false)
+		value, ok, done := ct.ilookup(root, key, hash, 0, nil, root.gen)
+		if done {
+			return value, ok
+		}
+	}
+}
+
+// Store 设置键对应的值。
+func (ct *Ctrie[K, V]) Store(key K, value V) {
+	ct.insert(key, value, false)
+}
+
+// LoadOrStore 如果键存在，返回已有的值，loaded为true；否则保存并返回value。
+func (ct *Ctrie[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	old, loaded := ct.insert(key, value, true)
+	if loaded {
+		return old, true
+	}
+	return value, false
+}
+
+// Swap 设置键对应的值，返回旧值。
+func (ct *Ctrie[K, V]) Swap(key K, value V) (previous V, loaded bool) {
+	return ct.insert(key, value, false)
+}
+
+// insert 插入键值对。onlyIfAbsent为true时，不覆盖已存在的键。
+func (ct *Ctrie[K, V]) insert(key K, value V, onlyIfAbsent bool) (old V, loaded bool) {
+	ct.mustWritable()
+
+	sn := &ctrieSNode[K, V]{key: key, value: value, hash: ct.hasher(key)}
+	for {
+		root := ct.readRoot(false)
+		old, loaded, done := ct.iinsert(root, sn, 0, nil, root.gen, onlyIfAbsent)
+		if done {
+			return old, loaded
+		}
+	}
+}
+
+// Delete 删除键。
+func (ct *Ctrie[K, V]) Delete(key K) {
+	ct.LoadAndDelete(key)
+}
+
+// LoadAndDelete 删除键，返回删除前的值。
+func (ct *Ctrie[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
+	ct.mustWritable()
+
+	hash := ct.hasher(key)
+	for {
+		root := ct.readRoot(false)
+		value, loaded, done := ct.iremove(root, key, hash, 0, nil, root.gen, nil)
+		if done {
+			return value, loaded
+		}
+	}
+}
+
+// CompareAndDelete 如果键对应的值等于old，删除键。返回是否删除了。
+// 值通过接口比较，类型不可比较时panic，同sync.Map。
+func (ct *Ctrie[K, V]) CompareAndDelete(key K, old V) (deleted bool) {
+	ct.mustWritable()
+
+	hash := ct.hasher(key)
+	matches := func(value V) bool {
+		return any(value) == any(old)
+	}
+	for {
+		root := ct.readRoot(false)
+		_, loaded, done := ct.iremove(root, key, hash, 0, nil, root.gen, matches)
+		if done {
+			return loaded
+		}
+	}
+}
+
+// Snapshot 返回一个可写的快照。常数时间。
+// 快照与原Ctrie共享节点，之后各自的修改互不可见。
+func (ct *Ctrie[K, V]) Snapshot() *Ctrie[K, V] {
+	ct.mustWritable()
+
+	for {
+		root := ct.rdcssComplete(false)
+		expectedMain := ct.gcasRead(root.iNode)
+		if ct.rdcssRoot(root, expectedMain, ct.copyToGen(expectedMain, &ctrieGen{})) {
+			snapshot := &Ctrie[K, V]{hasher: ct.hasher}
+			snapshot.root.Store(&ctrieRoot[K, V]{iNode: ct.copyToGen(expectedMain, &ctrieGen{})})
+			return snapshot
+		}
+	}
+}
+
+// ReadOnlySnapshot 返回一个只读的快照。常数时间。
+// 只读快照的读操作不需要复制节点，比可写快照更轻。
+func (ct *Ctrie[K, V]) ReadOnlySnapshot() *Ctrie[K, V] {
+	if ct.readOnly {
+		return ct
+	}
+
+	for {
+		root := ct.rdcssComplete(false)
+		expectedMain := ct.gcasRead(root.iNode)
+		if ct.rdcssRoot(root, expectedMain, ct.copyToGen(expectedMain, &ctrieGen{})) {
+			snapshot := &Ctrie[K, V]{hasher: ct.hasher, readOnly: true}
+			snapshot.root.Store(root)
+			return snapshot
+		}
+	}
+}
+
+// Range 遍历。遍历的是调用时刻的一致视图，不受并发修改的影响。
+func (ct *Ctrie[K, V]) Range(f func(key K, value V) (stopIteration bool)) {
+	snapshot := ct.ReadOnlySnapshot()
+	snapshot.rangeINode(snapshot.readRoot(false), f)
+}
+
+// Length 长度。
+func (ct *Ctrie[K, V]) Length() int {
+	var length int
+	ct.Range(func(key K, value V) (stopIteration bool) {
+		length++
+		return false
+	})
+	return length
+}
+
+// mustWritable 只读快照不可修改。
+func (ct *Ctrie[K, V]) mustWritable() {
+	if ct.readOnly {
+		panic("read-only ctrie")
+	}
+}
+
+// ilookup 查找。done为false时，需要从根重新开始。
+func (ct *Ctrie[K, V]) ilookup(in *ctrieINode[K, V], key K, hash uint64, lev int, parent *ctrieINode[K, V], startGen *ctrieGen) (value V, ok bool, done bool) {
+	m := ct.gcasRead(in)
+	switch {
+	case m.cNode != nil:
+		cn := m.cNode
+		flag, pos := ctrieFlagPos(hash, lev, cn.bitmap)
+		if cn.bitmap&flag == 0 {
+			return value, false, true
+		}
+		switch branch := cn.array[pos].(type) {
+		case *ctrieINode[K, V]:
+			if ct.readOnly || startGen == branch.gen {
+				return ct.ilookup(branch, key, hash, lev+ctrieLevelBits, in, startGen)
+			}
+			if ct.gcas(in, m, &ctrieMainNode[K, V]{cNode: ct.renewed(cn, startGen)}) {
+				return ct.ilookup(in, key, hash, lev, parent, startGen)
+			}
+			return value, false, false
+		case *ctrieSNode[K, V]:
+			if branch.hash == hash && branch.key == key {
+				return branch.value, true, true
+			}
+			return value, false, true
+		}
+	case m.tNode != nil:
+		if ct.readOnly {
+			if m.tNode.hash == hash && m.tNode.key == key {
+				return m.tNode.value, true, true
+			}
+			return value, false, true
+		}
+		ct.clean(parent, lev-ctrieLevelBits)
+		return value, false, false
+	case m.lNode != nil:
+		value, ok = m.lNode.lookup(key)
+		return value, ok, true
+	}
+	panic("impossibility")
+}
+
+// iinsert 插入。done为false时，需要从根重新开始。
+func (ct *Ctrie[K, V]) iinsert(in *ctrieINode[K, V], sn *ctrieSNode[K, V], lev int, parent *ctrieINode[K, V], startGen *ctrieGen, onlyIfAbsent bool) (old V, loaded bool, done bool) {
+	m := ct.gcasRead(in)
+	switch {
+	case m.cNode != nil:
+		cn := m.cNode
+		flag, pos := ctrieFlagPos(sn.hash, lev, cn.bitmap)
+		if cn.bitmap&flag == 0 {
+			renewed := cn
+			if cn.gen != in.gen {
+				renewed = ct.renewed(cn, in.gen)
+			}
+			ncn := renewed.insertedAt(pos, flag, sn, in.gen)
+			return old, false, ct.gcas(in, m, &ctrieMainNode[K, V]{cNode: ncn})
+		}
+		switch branch := cn.array[pos].(type) {
+		case *ctrieINode[K, V]:
+			if startGen == branch.gen {
+				return ct.iinsert(branch, sn, lev+ctrieLevelBits, in, startGen, onlyIfAbsent)
+			}
+			if ct.gcas(in, m, &ctrieMainNode[K, V]{cNode: ct.renewed(cn, startGen)}) {
+				return ct.iinsert(in, sn, lev, parent, startGen, onlyIfAbsent)
+			}
+			return old, false, false
+		case *ctrieSNode[K, V]:
+			if branch.hash == sn.hash && branch.key == sn.key {
+				if onlyIfAbsent {
+					return branch.value, true, true
+				}
+				ncn := cn.updatedAt(pos, sn, in.gen)
+				return branch.value, true, ct.gcas(in, m, &ctrieMainNode[K, V]{cNode: ncn})
+			}
+			renewed := cn
+			if cn.gen != in.gen {
+				renewed = ct.renewed(cn, in.gen)
+			}
+			nin := &ctrieINode[K, V]{gen: in.gen}
+			nin.main.Store(newCtrieDual(branch, sn, lev+ctrieLevelBits, in.gen))
+			ncn := renewed.updatedAt(pos, nin, in.gen)
+			return old, false, ct.gcas(in, m, &ctrieMainNode[K, V]{cNode: ncn})
+		}
+	case m.tNode != nil:
+		ct.clean(parent, lev-ctrieLevelBits)
+		return old, false, false
+	case m.lNode != nil:
+		old, loaded = m.lNode.lookup(sn.key)
+		if loaded && onlyIfAbsent {
+			return old, true, true
+		}
+		return old, loaded, ct.gcas(in, m, &ctrieMainNode[K, V]{lNode: m.lNode.inserted(sn)})
+	}
+	panic("impossibility")
+}
+
+// iremove 删除。matches不为nil时，只删除使它返回true的值。done为false时，需要从根重新开始。
+func (ct *Ctrie[K, V]) iremove(in *ctrieINode[K, V], key K, hash uint64, lev int, parent *ctrieINode[K, V], startGen *ctrieGen, matches func(value V) bool) (value V, loaded bool, done bool) {
+	m := ct.gcasRead(in)
+	switch {
+	case m.cNode != nil:
+		cn := m.cNode
+		flag, pos := ctrieFlagPos(hash, lev, cn.bitmap)
+		if cn.bitmap&flag == 0 {
+			return value, false, true
+		}
+		switch branch := cn.array[pos].(type) {
+		case *ctrieINode[K, V]:
+			if startGen != branch.gen {
+				if ct.gcas(in, m, &ctrieMainNode[K, V]{cNode: ct.renewed(cn, startGen)}) {
+					return ct.iremove(in, key, hash, lev, parent, startGen, matches)
+				}
+				return value, false, false
+			}
+			value, loaded, done = ct.iremove(branch, key, hash, lev+ctrieLevelBits, in, startGen, matches)
+		case *ctrieSNode[K, V]:
+			if branch.hash != hash || branch.key != key {
+				return value, false, true
+			}
+			if matches != nil && !matches(branch.value) {
+				return value, false, true
+			}
+			ncn := cn.removedAt(pos, flag, in.gen).toContracted(lev)
+			if !ct.gcas(in, m, ncn) {
+				return value, false, false
+			}
+			value, loaded, done = branch.value, true, true
+		}
+		if loaded && done && parent != nil {
+			// 只剩一个键值对的节点，变成了墓碑。压缩到父节点。
+			if n := ct.gcasRead(in); n.tNode != nil {
+				ct.cleanParent(n, in, parent, hash, lev-ctrieLevelBits, startGen)
+			}
+		}
+		return value, loaded, done
+	case m.tNode != nil:
+		ct.clean(parent, lev-ctrieLevelBits)
+		return value, false, false
+	case m.lNode != nil:
+		value, loaded = m.lNode.lookup(key)
+		if !loaded || (matches != nil && !matches(value)) {
+			return value, false, true
+		}
+		if ct.gcas(in, m, m.lNode.removed(key)) {
+			return value, true, true
+		}
+		return value, false, false
+	}
+	panic("impossibility")
+}
+
+// clean 压缩节点：把子节点中的墓碑复活到当前节点。
+func (ct *Ctrie[K, V]) clean(in *ctrieINode[K, V], lev int) {
+	m := ct.gcasRead(in)
+	if m.cNode != nil {
+		ct.gcas(in, m, ct.compressed(m.cNode, lev, in.gen))
+	}
+}
+
+// cleanParent 把变成墓碑的in，替换为墓碑内的键值对。
+func (ct *Ctrie[K, V]) cleanParent(nonLive *ctrieMainNode[K, V], in, parent *ctrieINode[K, V], hash uint64, lev int, startGen *ctrieGen) {
+	for {
+		pm := ct.gcasRead(parent)
+		if pm.cNode == nil {
+			return
+		}
+		cn := pm.cNode
+		flag, pos := ctrieFlagPos(hash, lev, cn.bitmap)
+		if cn.bitmap&flag == 0 || cn.array[pos] != ctrieBranch[K, V](in) {
+			// 已经被其它过程清理
+			return
+		}
+		ncn := cn.updatedAt(pos, nonLive.tNode, parent.gen).toContracted(lev)
+		if ct.gcas(parent, pm, ncn) {
+			return
+		}
+		if ct.readRoot(false).gen != startGen {
+			return
+		}
+	}
+}
+
+// renewed 复制cNode，子iNode复制到新的代。
+func (ct *Ctrie[K, V]) renewed(cn *ctrieCNode[K, V], gen *ctrieGen) *ctrieCNode[K, V] {
+	array := make([]ctrieBranch[K, V], len(cn.array))
+	for i, branch := range cn.array {
+		if in, ok := branch.(*ctrieINode[K, V]); ok {
+			array[i] = ct.copyToGen(ct.gcasRead(in), gen)
+		} else {
+			array[i] = branch
+		}
+	}
+	return &ctrieCNode[K, V]{bitmap: cn.bitmap, array: array, gen: gen}
+}
+
+// compressed 复活子节点中的墓碑后，收缩。
+func (ct *Ctrie[K, V]) compressed(cn *ctrieCNode[K, V], lev int, gen *ctrieGen) *ctrieMainNode[K, V] {
+	array := make([]ctrieBranch[K, V], len(cn.array))
+	for i, branch := range cn.array {
+		array[i] = branch
+		if in, ok := branch.(*ctrieINode[K, V]); ok {
+			if m := ct.gcasRead(in); m.tNode != nil {
+				array[i] = m.tNode
+			}
+		}
+	}
+	ncn := &ctrieCNode[K, V]{bitmap: cn.bitmap, array: array, gen: gen}
+	return ncn.toContracted(lev)
+}
+
+// copyToGen 新建一个指定代的iNode，指向main。
+func (ct *Ctrie[K, V]) copyToGen(main *ctrieMainNode[K, V], gen *ctrieGen) *ctrieINode[K, V] {
+	in := &ctrieINode[K, V]{gen: gen}
+	in.main.Store(main)
+	return in
+}
+
+// rangeINode 递归遍历。只用于只读快照。
+func (ct *Ctrie[K, V]) rangeINode(in *ctrieINode[K, V], f func(key K, value V) (stopIteration bool)) (stop bool) {
+	m := ct.gcasRead(in)
+	switch {
+	case m.cNode != nil:
+		for _, branch := range m.cNode.array {
+			switch branch := branch.(type) {
+			case *ctrieINode[K, V]:
+				if ct.rangeINode(branch, f) {
+					return true
+				}
+			case *ctrieSNode[K, V]:
+				if f(branch.key, branch.value) {
+					return true
+				}
+			}
+		}
+	case m.tNode != nil:
+		return f(m.tNode.key, m.tNode.value)
+	case m.lNode != nil:
+		for _, sn := range m.lNode.entries {
+			if f(sn.key, sn.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gcas 生成感知的CAS。只有在根的代与in的代一致时才提交，否则回滚。
+func (ct *Ctrie[K, V]) gcas(in *ctrieINode[K, V], old, n *ctrieMainNode[K, V]) bool {
+	n.prev.Store(old)
+	if in.main.CompareAndSwap(old, n) {
+		ct.gcasCommit(in, n)
+		return n.prev.Load() == nil
+	}
+	return false
+}
+
+// gcasRead 读取已提交的main。
+func (ct *Ctrie[K, V]) gcasRead(in *ctrieINode[K, V]) *ctrieMainNode[K, V] {
+	m := in.main.Load()
+	if m.prev.Load() == nil {
+		return m
+	}
+	return ct.gcasCommit(in, m)
+}
+
+// gcasCommit 完成进行中的GCAS，返回已提交的main。
+func (ct *Ctrie[K, V]) gcasCommit(in *ctrieINode[K, V], m *ctrieMainNode[K, V]) *ctrieMainNode[K, V] {
+	for {
+		prev := m.prev.Load()
+		root := ct.readRoot(true)
+		if prev == nil {
+			return m
+		}
+
+		if prev.failed != nil {
+			// 回滚
+			if in.main.CompareAndSwap(m, prev.failed) {
+				return prev.failed
+			}
+			m = in.main.Load()
+			continue
+		}
+
+		if root.gen == in.gen && !ct.readOnly {
+			if m.prev.CompareAndSwap(prev, nil) {
+				return m
+			}
+			continue
+		}
+
+		// 快照之后，旧代的节点不可写
+		m.prev.CompareAndSwap(prev, &ctrieMainNode[K, V]{failed: prev})
+		m = in.main.Load()
+	}
+}
+
+// readRoot 读取根iNode。abort为true时，中止进行中的RDCSS。
+func (ct *Ctrie[K, V]) readRoot(abort bool) *ctrieINode[K, V] {
+	root := ct.root.Load()
+	if root.desc == nil {
+		return root.iNode
+	}
+	return ct.rdcssComplete(abort).iNode
+}
+
+// rdcssRoot 当old仍是根且其main仍是expectedMain时，替换根为nv。
+func (ct *Ctrie[K, V]) rdcssRoot(old *ctrieRoot[K, V], expectedMain *ctrieMainNode[K, V], nv *ctrieINode[K, V]) bool {
+	desc := &ctrieDescriptor[K, V]{
+		old:          old,
+		expectedMain: expectedMain,
+		new:          &ctrieRoot[K, V]{iNode: nv},
+	}
+	if ct.root.CompareAndSwap(old, &ctrieRoot[K, V]{desc: desc}) {
+		ct.rdcssComplete(false)
+		return desc.state.Load() == ctrieCommitted
+	}
+	return false
+}
+
+// rdcssComplete 完成进行中的RDCSS，返回根。
+func (ct *Ctrie[K, V]) rdcssComplete(abort bool) *ctrieRoot[K, V] {
+	for {
+		root := ct.root.Load()
+		if root.desc == nil {
+			return root
+		}
+
+		desc := root.desc
+		if desc.state.Load() == ctrieUndecided {
+			if abort {
+				desc.state.CompareAndSwap(ctrieUndecided, ctrieAborted)
+			} else if ct.gcasRead(desc.old.iNode) == desc.expectedMain {
+				desc.state.CompareAndSwap(ctrieUndecided, ctrieCommitted)
+			} else {
+				desc.state.CompareAndSwap(ctrieUndecided, ctrieAborted)
+			}
+		}
+
+		if desc.state.Load() == ctrieCommitted {
+			ct.root.CompareAndSwap(root, desc.new)
+		} else {
+			ct.root.CompareAndSwap(root, desc.old)
+		}
+	}
+}
+
+// ctrieFlagPos 计算哈希在当前层的标志位，和在数组中的位置。
+func ctrieFlagPos(hash uint64, lev int, bitmap uint64) (flag uint64, pos int) {
+	index := (hash >> uint(lev)) & ctrieLevelMask
+	flag = 1 << index
+	pos = bits.OnesCount64(bitmap & (flag - 1))
+	return flag, pos
+}
+
+// newCtrieDual 为两个键值对新建节点。
+func newCtrieDual[K comparable, V any](x, y *ctrieSNode[K, V], lev int, gen *ctrieGen) *ctrieMainNode[K, V] {
+	if lev >= ctrieMaxLevel {
+		return &ctrieMainNode[K, V]{lNode: &ctrieLNode[K, V]{entries: []*ctrieSNode[K, V]{x, y}}}
+	}
+
+	xIndex := (x.hash >> uint(lev)) & ctrieLevelMask
+	yIndex := (y.hash >> uint(lev)) & ctrieLevelMask
+	bitmap := uint64(1)<<xIndex | uint64(1)<<yIndex
+	var array []ctrieBranch[K, V]
+	switch {
+	case xIndex == yIndex:
+		sub := &ctrieINode[K, V]{gen: gen}
+		sub.main.Store(newCtrieDual(x, y, lev+ctrieLevelBits, gen))
+		array = []ctrieBranch[K, V]{sub}
+	case xIndex < yIndex:
+		array = []ctrieBranch[K, V]{x, y}
+	default:
+		array = []ctrieBranch[K, V]{y, x}
+	}
+	return &ctrieMainNode[K, V]{cNode: &ctrieCNode[K, V]{bitmap: bitmap, array: array, gen: gen}}
+}
+
+// insertedAt 返回插入分支后的新cNode。
+func (cn *ctrieCNode[K, V]) insertedAt(pos int, flag uint64, branch ctrieBranch[K, V], gen *ctrieGen) *ctrieCNode[K, V] {
+	array := make([]ctrieBranch[K, V], len(cn.array)+1)
+	copy(array, cn.array[:pos])
+	array[pos] = branch
+	copy(array[pos+1:], cn.array[pos:])
+	return &ctrieCNode[K, V]{bitmap: cn.bitmap | flag, array: array, gen: gen}
+}
+
+// updatedAt 返回替换分支后的新cNode。
+func (cn *ctrieCNode[K, V]) updatedAt(pos int, branch ctrieBranch[K, V], gen *ctrieGen) *ctrieCNode[K, V] {
+	array := make([]ctrieBranch[K, V], len(cn.array))
+	copy(array, cn.array)
+	array[pos] = branch
+	return &ctrieCNode[K, V]{bitmap: cn.bitmap, array: array, gen: gen}
+}
+
+// removedAt 返回删除分支后的新cNode。
+func (cn *ctrieCNode[K, V]) removedAt(pos int, flag uint64, gen *ctrieGen) *ctrieCNode[K, V] {
+	array := make([]ctrieBranch[K, V], len(cn.array)-1)
+	copy(array, cn.array[:pos])
+	copy(array[pos:], cn.array[pos+1:])
+	return &ctrieCNode[K, V]{bitmap: cn.bitmap ^ flag, array: array, gen: gen}
+}
+
+// toContracted 非根节点只剩一个键值对时，变成墓碑。
+func (cn *ctrieCNode[K, V]) toContracted(lev int) *ctrieMainNode[K, V] {
+	if lev > 0 && len(cn.array) == 1 {
+		if sn, ok := cn.array[0].(*ctrieSNode[K, V]); ok {
+			return &ctrieMainNode[K, V]{tNode: sn}
+		}
+	}
+	return &ctrieMainNode[K, V]{cNode: cn}
+}
+
+// lookup 查找键。
+func (ln *ctrieLNode[K, V]) lookup(key K) (value V, ok bool) {
+	for _, sn := range ln.entries {
+		if sn.key == key {
+			return sn.value, true
+		}
+	}
+	return value, false
+}
+
+// inserted 返回插入或替换键值对后的新lNode。
+func (ln *ctrieLNode[K, V]) inserted(sn *ctrieSNode[K, V]) *ctrieLNode[K, V] {
+	entries := make([]*ctrieSNode[K, V], 0, len(ln.entries)+1)
+	for _, entry := range ln.entries {
+		if entry.key != sn.key {
+			entries = append(entries, entry)
+		}
+	}
+	entries = append(entries, sn)
+	return &ctrieLNode[K, V]{entries: entries}
+}
+
+// removed 返回删除键后的新节点。只剩一个键值对时，变成墓碑。
+func (ln *ctrieLNode[K, V]) removed(key K) *ctrieMainNode[K, V] {
+	entries := make([]*ctrieSNode[K, V], 0, len(ln.entries))
+	for _, entry := range ln.entries {
+		if entry.key != key {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 1 {
+		return &ctrieMainNode[K, V]{tNode: entries[0]}
+	}
+	return &ctrieMainNode[K, V]{lNode: &ctrieLNode[K, V]{entries: entries}}
+}
@@ -0,0 +1,238 @@
+package freesync
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCtrie(t *testing.T) {
+	ct := NewCtrie[int, int]()
+
+	_, ok := ct.Load(1)
+	assert.False(t, ok)
+
+	for i := 0; i < 10000; i++ {
+		ct.Store(i, i*10)
+	}
+	assert.Equal(t, 10000, ct.Length())
+	for i := 0; i < 10000; i++ {
+		value, ok := ct.Load(i)
+		if !assert.True(t, ok) || !assert.Equal(t, i*10, value) {
+			t.FailNow()
+		}
+	}
+
+	// 覆盖
+	old, loaded := ct.Swap(1, 1)
+	assert.True(t, loaded)
+	assert.Equal(t, 10, old)
+
+	actual, loaded := ct.LoadOrStore(2, 2)
+	assert.True(t, loaded)
+	assert.Equal(t, 20, actual)
+	actual, loaded = ct.LoadOrStore(-1, -1)
+	assert.False(t, loaded)
+	assert.Equal(t, -1, actual)
+
+	// 删除
+	for i := 0; i < 10000; i += 2 {
+		value, loaded := ct.LoadAndDelete(i)
+		assert.True(t, loaded)
+		if i != 2 {
+			assert.Equal(t, i*10, value)
+		}
+	}
+	_, loaded = ct.LoadAndDelete(0)
+	assert.False(t, loaded)
+	assert.Equal(t, 5001, ct.Length())
+
+	for i := 1; i < 10000; i += 2 {
+		ct.Delete(i)
+	}
+	ct.Delete(-1)
+	assert.Equal(t, 0, ct.Length())
+}
+
+func TestCtrie_HashCollision(t *testing.T) {
+	// 所有键哈希冲突，走lNode
+	ct := NewCtrieWithHasher[string, int](func(key string) uint64 { return 42 })
+
+	ct.Store("a", 1)
+	ct.Store("b", 2)
+	ct.Store("c", 3)
+	ct.Store("b", 20)
+	assert.Equal(t, 3, ct.Length())
+
+	value, ok := ct.Load("b")
+	assert.True(t, ok)
+	assert.Equal(t, 20, value)
+
+	ct.Delete("a")
+	ct.Delete("c")
+	value, ok = ct.Load("b")
+	assert.True(t, ok)
+	assert.Equal(t, 20, value)
+	_, ok = ct.Load("a")
+	assert.False(t, ok)
+
+	ct.Delete("b")
+	assert.Equal(t, 0, ct.Length())
+}
+
+func TestCtrie_CompareAndDelete(t *testing.T) {
+	for _, ct := range []*Ctrie[string, int]{
+		NewCtrie[string, int](),
+		// 哈希冲突，走lNode
+		NewCtrieWithHasher[string, int](func(key string) uint64 { return 42 }),
+	} {
+		ct.Store("a", 1)
+		ct.Store("b", 2)
+
+		assert.False(t, ct.CompareAndDelete("a", 2))
+		assert.False(t, ct.CompareAndDelete("c", 1))
+		_, ok := ct.Load("a")
+		assert.True(t, ok)
+
+		assert.True(t, ct.CompareAndDelete("a", 1))
+		_, ok = ct.Load("a")
+		assert.False(t, ok)
+		assert.Equal(t, 1, ct.Length())
+	}
+}
+
+func TestCtrie_Snapshot(t *testing.T) {
+	ct := NewCtrie[int, int]()
+	for i := 0; i < 1000; i++ {
+		ct.Store(i, i)
+	}
+
+	snapshot := ct.Snapshot()
+	readOnly := ct.ReadOnlySnapshot()
+
+	// 各自修改，互不影响
+	for i := 0; i < 1000; i++ {
+		ct.Store(i, -i)
+	}
+	for i := 0; i < 500; i++ {
+		snapshot.Delete(i)
+	}
+
+	for i := 0; i < 1000; i++ {
+		value, _ := ct.Load(i)
+		assert.Equal(t, -i, value)
+
+		value, ok := snapshot.Load(i)
+		if i < 500 {
+			assert.False(t, ok)
+		} else {
+			assert.Equal(t, i, value)
+		}
+
+		value, _ = readOnly.Load(i)
+		assert.Equal(t, i, value)
+	}
+	assert.Equal(t, 1000, ct.Length())
+	assert.Equal(t, 500, snapshot.Length())
+	assert.Equal(t, 1000, readOnly.Length())
+
+	assert.Panics(t, func() {
+		readOnly.Store(1, 1)
+	})
+}
+
+func TestCtrie_ConcurrentlyUpdate(t *testing.T) {
+	ct := NewCtrie[int, int]()
+	big := 50000
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for _, num := range rand.Perm(big) {
+				ct.Store(num, num)
+				if num%2 == 1 {
+					ct.Delete(num)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	all := make([]int, 0, big/2)
+	ct.Range(func(key, value int) (stopIteration bool) {
+		assert.Equal(t, key, value)
+		all = append(all, key)
+		return false
+	})
+	sort.Ints(all)
+
+	want := make([]int, 0, big/2)
+	for i := 0; i < big; i += 2 {
+		want = append(want, i)
+	}
+	assert.Equal(t, want, all)
+}
+
+func TestCtrie_ConcurrentlySnapshot(t *testing.T) {
+	// 写入过程中做快照，快照内的每一轮写入要么全部可见，要么全部不可见
+	ct := NewCtrie[int, int]()
+	keys := 64
+
+	var done uint64
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for round := 1; atomic.LoadUint64(&done) == 0; round++ {
+			// 每一轮只有一个写者，写完后再写下一轮
+			for key := 0; key < keys; key++ {
+				ct.Store(key, round)
+			}
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		snapshot := ct.ReadOnlySnapshot()
+
+		// 快照中，键的值按键的顺序单调不增，相邻最多差一轮
+		var rounds []int
+		for key := 0; key < keys; key++ {
+			value, ok := snapshot.Load(key)
+			if !ok {
+				value = 0
+			}
+			rounds = append(rounds, value)
+		}
+		for key := 1; key < keys; key++ {
+			if rounds[key] > rounds[key-1] || rounds[0]-rounds[key] > 1 {
+				t.Fatalf("inconsistent snapshot: %v", rounds)
+			}
+		}
+
+		// 快照不随原Ctrie改变
+		again := make([]int, 0, keys)
+		snapshot.Range(func(key, value int) (stopIteration bool) {
+			again = append(again, value)
+			return false
+		})
+		sort.Sort(sort.Reverse(sort.IntSlice(again)))
+		nonZero := make([]int, 0, keys)
+		for _, value := range rounds {
+			if value != 0 {
+				nonZero = append(nonZero, value)
+			}
+		}
+		assert.Equal(t, nonZero, again)
+	}
+
+	atomic.StoreUint64(&done, 1)
+	wg.Wait()
+}
@@ -0,0 +1,191 @@
+package freesync
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrNoFutures Any没有传入任何Future。
+var ErrNoFutures = errors.New("no futures")
+
+// futureResult Future的结果。
+type futureResult[T any] struct {
+	value T
+	err   error
+}
+
+// futureCallback 完成时调用的回调。无锁栈的节点。
+type futureCallback[T any] struct {
+	f    func(value T, err error)
+	next *futureCallback[T]
+}
+
+// Future 一次性的异步结果。
+// 第一次Complete决定结果，之后的Complete无效。
+// 回调保存在无锁栈中；完成时取走整个栈并封闭，之后注册的回调立即调用。
+// 零值不可用，必须通过NewFuture创建。
+type Future[T any] struct {
+	result atomic.Pointer[futureResult[T]]
+
+	// done 完成后关闭。
+	done chan struct{}
+
+	// callbacks 等待完成的回调。完成后为closed。
+	callbacks atomic.Pointer[futureCallback[T]]
+
+	// closed 回调栈已封闭的标记。
+	closed *futureCallback[T]
+}
+
+// NewFuture 新建一个没有完成的Future。
+func NewFuture[T any]() *Future[T] {
+	return &Future[T]{
+		done:   make(chan struct{}),
+		closed: &futureCallback[T]{},
+	}
+}
+
+// Complete 完成。只有第一次Complete生效，返回true。
+// 注册的回调在当前过程中按注册顺序调用。
+func (future *Future[T]) Complete(value T, err error) bool {
+	if !future.result.CompareAndSwap(nil, &futureResult[T]{value: value, err: err}) {
+		return false
+	}
+	close(future.done)
+
+	// 取走已注册的回调，并封闭
+	head := future.callbacks.Swap(future.closed)
+	var callbacks []func(value T, err error)
+	for node := head; node != nil; node = node.next {
+		callbacks = append(callbacks, node.f)
+	}
+	for i := len(callbacks) - 1; i >= 0; i-- {
+		callbacks[i](value, err)
+	}
+	return true
+}
+
+// Done 完成后关闭的通道。
+func (future *Future[T]) Done() <-chan struct{} {
+	return future.done
+}
+
+// Get 等待完成并返回结果。ctx结束时，返回ctx.Err()。
+func (future *Future[T]) Get(ctx context.Context) (T, error) {
+	select {
+	case <-future.done:
+		result := future.result.Load()
+		return result.value, result.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// TryGet 不等待，返回结果。没有完成时，ok为false。
+func (future *Future[T]) TryGet() (value T, err error, ok bool) {
+	result := future.result.Load()
+	if result == nil {
+		return value, nil, false
+	}
+	return result.value, result.err, true
+}
+
+// OnComplete 注册完成时调用的回调。已经完成时，在当前过程中立即调用。
+func (future *Future[T]) OnComplete(f func(value T, err error)) {
+	node := &futureCallback[T]{f: f}
+	for {
+		head := future.callbacks.Load()
+		if head == future.closed {
+			result := future.result.Load()
+			f(result.value, result.err)
+			return
+		}
+		node.next = head
+		if future.callbacks.CompareAndSwap(head, node) {
+			return
+		}
+	}
+}
+
+// All 全部成功时，按顺序返回全部结果；任何一个失败时，以第一个失败的错误完成。
+func All[T any](futures ...*Future[T]) *Future[[]T] {
+	all := NewFuture[[]T]()
+	if len(futures) == 0 {
+		all.Complete([]T{}, nil)
+		return all
+	}
+
+	values := make([]T, len(futures))
+	var remaining atomic.Int64
+	remaining.Store(int64(len(futures)))
+	for i, future := range futures {
+		i := i
+		future.OnComplete(func(value T, err error) {
+			if err != nil {
+				all.Complete(nil, err)
+				return
+			}
+			values[i] = value
+			// 最后一个完成的过程，能看到其它过程写入的结果
+			if remaining.Add(-1) == 0 {
+				all.Complete(values, nil)
+			}
+		})
+	}
+	return all
+}
+
+// Any 以第一个成功的结果完成；全部失败时，以最后一个失败的错误完成。
+// 没有传入Future时，以ErrNoFutures失败。
+func Any[T any](futures ...*Future[T]) *Future[T] {
+	first := NewFuture[T]()
+	if len(futures) == 0 {
+		var zero T
+		first.Complete(zero, ErrNoFutures)
+		return first
+	}
+
+	var remaining atomic.Int64
+	remaining.Store(int64(len(futures)))
+	for _, future := range futures {
+		future.OnComplete(func(value T, err error) {
+			if err == nil {
+				first.Complete(value, nil)
+				return
+			}
+			if remaining.Add(-1) == 0 {
+				first.Complete(value, err)
+			}
+		})
+	}
+	return first
+}
+
+// Then future成功后，用它的结果调用fn，返回fn结果的Future。future失败时，不调用fn，直接传递错误。
+// fn panic时，返回的Future以*PanicError失败；fn调用runtime.Goexit时，以ErrGoexit失败。
+// fn在完成future的过程中调用，不应长时间阻塞。
+func Then[T, U any](future *Future[T], fn func(value T) (U, error)) *Future[U] {
+	next := NewFuture[U]()
+	future.OnComplete(func(value T, err error) {
+		if err != nil {
+			var zero U
+			next.Complete(zero, err)
+			return
+		}
+		// 恢复fn的panic，保证next总会完成，等待它的过程不会一直等下去
+		call := newSharedCall[U]()
+		call.run(func() (U, error) {
+			return fn(value)
+		}, func() {
+			if call.panicErr != nil {
+				var zero U
+				next.Complete(zero, call.panicErr)
+				return
+			}
+			next.Complete(call.value, call.err)
+		})
+	})
+	return next
+}
@@ -0,0 +1,161 @@
+package freesync
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuture(t *testing.T) {
+	future := NewFuture[int]()
+	_, _, ok := future.TryGet()
+	assert.False(t, ok)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := future.Get(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	var order []int
+	future.OnComplete(func(value int, err error) {
+		order = append(order, 1)
+	})
+	future.OnComplete(func(value int, err error) {
+		order = append(order, 2)
+	})
+
+	assert.True(t, future.Complete(5, nil))
+	assert.False(t, future.Complete(6, errors.New("late")))
+	assert.Equal(t, []int{1, 2}, order)
+
+	value, err := future.Get(context.Background())
+	assert.Equal(t, 5, value)
+	assert.NoError(t, err)
+	<-future.Done()
+
+	// 完成后注册，立即调用
+	var got int
+	future.OnComplete(func(value int, err error) {
+		got = value
+	})
+	assert.Equal(t, 5, got)
+}
+
+func TestFuture_ConcurrentlyComplete(t *testing.T) {
+	// 只有一次Complete生效；每个回调恰好调用一次
+	for round := 0; round < 100; round++ {
+		future := NewFuture[int]()
+		var calls atomic.Int64
+		var wins atomic.Int64
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func(i int) {
+				defer wg.Done()
+				if future.Complete(i, nil) {
+					wins.Add(1)
+				}
+			}(i)
+			go func() {
+				defer wg.Done()
+				future.OnComplete(func(value int, err error) {
+					calls.Add(1)
+				})
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int64(1), wins.Load())
+		assert.Equal(t, int64(10), calls.Load())
+	}
+}
+
+func TestAll(t *testing.T) {
+	futures := []*Future[int]{NewFuture[int](), NewFuture[int](), NewFuture[int]()}
+	all := All(futures...)
+	for i := len(futures) - 1; i >= 0; i-- {
+		go futures[i].Complete(i*10, nil)
+	}
+	values, err := all.Get(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 10, 20}, values)
+
+	// 第一个错误
+	failing := []*Future[int]{NewFuture[int](), NewFuture[int]()}
+	all = All(failing...)
+	wantErr := errors.New("failed")
+	failing[1].Complete(0, wantErr)
+	_, err = all.Get(context.Background())
+	assert.Equal(t, wantErr, err)
+
+	values, err = All[int]().Get(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+}
+
+func TestAny(t *testing.T) {
+	futures := []*Future[int]{NewFuture[int](), NewFuture[int](), NewFuture[int]()}
+	first := Any(futures...)
+	futures[0].Complete(0, errors.New("failed"))
+	futures[2].Complete(2, nil)
+	futures[1].Complete(1, nil)
+	value, err := first.Get(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, value)
+
+	// 全部失败
+	failing := []*Future[int]{NewFuture[int](), NewFuture[int]()}
+	first = Any(failing...)
+	failing[0].Complete(0, errors.New("first"))
+	lastErr := errors.New("last")
+	failing[1].Complete(0, lastErr)
+	_, err = first.Get(context.Background())
+	assert.Equal(t, lastErr, err)
+
+	_, err = Any[int]().Get(context.Background())
+	assert.Equal(t, ErrNoFutures, err)
+}
+
+func TestThen(t *testing.T) {
+	future := NewFuture[int]()
+	next := Then(future, func(value int) (string, error) {
+		return strconv.Itoa(value * 2), nil
+	})
+	future.Complete(21, nil)
+	value, err := next.Get(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "42", value)
+
+	// 错误直接传递，不调用fn
+	failing := NewFuture[int]()
+	wantErr := errors.New("failed")
+	failing.Complete(0, wantErr)
+	next = Then(failing, func(value int) (string, error) {
+		t.Error("should not be called")
+		return "", nil
+	})
+	_, err = next.Get(context.Background())
+	assert.Equal(t, wantErr, err)
+}
+
+func TestThen_Panic(t *testing.T) {
+	future := NewFuture[int]()
+	next := Then(future, func(value int) (string, error) {
+		panic("boom")
+	})
+	future.Complete(1, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := next.Get(ctx)
+	var panicErr *PanicError
+	assert.ErrorAs(t, err, &panicErr)
+	assert.Equal(t, "boom", panicErr.Value)
+}
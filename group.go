@@ -0,0 +1,142 @@
+package freesync
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// GroupResult DoChan的结果。
+type GroupResult[V any] struct {
+	Value V
+	Err   error
+
+	// Shared 结果是否也给了其它调用者。
+	Shared bool
+}
+
+// groupCall 一次调用。
+type groupCall[V any] struct {
+	sharedCall[V]
+
+	// expireAt 缓存的结果过期的时间，UnixNano。done关闭后不再变化。
+	expireAt int64
+
+	// dups 共享这次调用的其它调用者数量。
+	dups atomic.Int64
+}
+
+// Group 重复调用抑制。同一个键同时只执行一次函数，其它调用者等待并共享结果。
+// 进行中的调用登记在无锁的Ctrie中，不同的键互不竞争。
+// 可以设置缓存窗口：调用成功后，窗口内的调用直接返回缓存的结果。返回错误或者panic的结果不缓存。
+type Group[K comparable, V any] struct {
+	calls *Ctrie[K, *groupCall[V]]
+
+	// window 成功结果的缓存时间。为0表示不缓存。
+	window time.Duration
+}
+
+// NewGroup 新建一个不缓存结果的Group。
+func NewGroup[K comparable, V any]() *Group[K, V] {
+	return NewGroupWithCache[K, V](0)
+}
+
+// NewGroupWithCache 新建一个Group，成功的结果缓存window时间。
+func NewGroupWithCache[K comparable, V any](window time.Duration) *Group[K, V] {
+	if window < 0 {
+		panic("window must be non-negative.")
+	}
+	return &Group[K, V]{
+		calls:  NewCtrie[K, *groupCall[V]](),
+		window: window,
+	}
+}
+
+// Do 执行fn并返回结果。同一个键有进行中的调用，或者有没过期的缓存时，等待并返回它的结果，shared为true。
+// fn panic时，所有等待者都以*PanicError panic；fn调用runtime.Goexit时，其它等待者得到ErrGoexit。
+func (group *Group[K, V]) Do(key K, fn func() (V, error)) (value V, err error, shared bool) {
+	call, loaded := group.register(key)
+	if loaded {
+		call.dups.Add(1)
+		value, err = call.result()
+		return value, err, true
+	}
+
+	group.doCall(key, call, fn)
+	value, err = call.result()
+	return value, err, call.dups.Load() > 0
+}
+
+// DoChan 同Do，但不等待，结果通过返回的通道送达。
+// fn panic时，panic发生在另外的协程中，进程会退出。
+func (group *Group[K, V]) DoChan(key K, fn func() (V, error)) <-chan GroupResult[V] {
+	ch := make(chan GroupResult[V], 1)
+	call, loaded := group.register(key)
+	if loaded {
+		call.dups.Add(1)
+	}
+	go func() {
+		// fn调用runtime.Goexit时，这个协程直接退出，在defer中依然送达结果
+		defer func() {
+			value, err := call.result()
+			ch <- GroupResult[V]{Value: value, Err: err, Shared: loaded || call.dups.Load() > 0}
+		}()
+		if !loaded {
+			group.doCall(key, call, fn)
+		}
+	}()
+	return ch
+}
+
+// Forget 忘记键对应的调用或者缓存。之后的调用会重新执行函数，不等待进行中的调用。
+func (group *Group[K, V]) Forget(key K) {
+	group.calls.Delete(key)
+}
+
+// register 登记一次调用。已有进行中的调用或者没过期的缓存时，返回它，loaded为true。
+func (group *Group[K, V]) register(key K) (call *groupCall[V], loaded bool) {
+	for {
+		if existing, ok := group.calls.Load(key); ok {
+			if !existing.expired() {
+				return existing, true
+			}
+			// 过期的缓存还没被定时器清除
+			group.calls.CompareAndDelete(key, existing)
+			continue
+		}
+
+		call = &groupCall[V]{sharedCall: newSharedCall[V]()}
+		if existing, loaded := group.calls.LoadOrStore(key, call); loaded {
+			if !existing.expired() {
+				return existing, true
+			}
+			group.calls.CompareAndDelete(key, existing)
+			continue
+		}
+		return call, false
+	}
+}
+
+// expired 调用已经结束，缓存的结果已经过期。
+func (call *groupCall[V]) expired() bool {
+	select {
+	case <-call.done:
+		return time.Now().UnixNano() >= call.expireAt
+	default:
+		return false
+	}
+}
+
+// doCall 执行fn，保存结果，通知等待者。
+func (group *Group[K, V]) doCall(key K, call *groupCall[V], fn func() (V, error)) {
+	call.run(fn, func() {
+		if group.window > 0 && call.err == nil && call.panicErr == nil {
+			call.expireAt = time.Now().Add(group.window).UnixNano()
+			time.AfterFunc(group.window, func() {
+				group.calls.CompareAndDelete(key, call)
+			})
+		} else {
+			// 不缓存。先删除，之后的调用重新执行
+			group.calls.CompareAndDelete(key, call)
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package freesync
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroup_Do(t *testing.T) {
+	group := NewGroup[string, int]()
+
+	value, err, shared := group.Do("a", func() (int, error) {
+		return 1, nil
+	})
+	assert.Equal(t, 1, value)
+	assert.NoError(t, err)
+	assert.False(t, shared)
+
+	// 不缓存
+	value, _, _ = group.Do("a", func() (int, error) {
+		return 2, nil
+	})
+	assert.Equal(t, 2, value)
+
+	wantErr := errors.New("failed")
+	_, err, _ = group.Do("a", func() (int, error) {
+		return 0, wantErr
+	})
+	assert.Equal(t, wantErr, err)
+}
+
+func TestGroup_Suppress(t *testing.T) {
+	group := NewGroup[string, int]()
+
+	var calls atomic.Int64
+	release := make(chan struct{})
+	fn := func() (int, error) {
+		calls.Add(1)
+		<-release
+		return 42, nil
+	}
+
+	var wg sync.WaitGroup
+	var sharedCount atomic.Int64
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			value, err, shared := group.Do("key", fn)
+			assert.Equal(t, 42, value)
+			assert.NoError(t, err)
+			if shared {
+				sharedCount.Add(1)
+			}
+		}()
+	}
+	// 不同的键不受影响
+	value, _, _ := group.Do("other", func() (int, error) {
+		return 1, nil
+	})
+	assert.Equal(t, 1, value)
+
+	// 等待全部调用者加入
+	for {
+		call, ok := group.calls.Load("key")
+		if ok && call.dups.Load() == 99 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int64(1), calls.Load())
+	assert.Equal(t, int64(100), sharedCount.Load())
+}
+
+func TestGroup_DoChan(t *testing.T) {
+	group := NewGroup[string, int]()
+	release := make(chan struct{})
+
+	ch1 := group.DoChan("key", func() (int, error) {
+		<-release
+		return 1, nil
+	})
+	ch2 := group.DoChan("key", func() (int, error) {
+		return 2, nil
+	})
+	close(release)
+
+	result1, result2 := <-ch1, <-ch2
+	assert.Equal(t, 1, result1.Value)
+	assert.Equal(t, 1, result2.Value)
+	assert.True(t, result1.Shared)
+	assert.True(t, result2.Shared)
+}
+
+func TestGroup_Forget(t *testing.T) {
+	group := NewGroup[string, int]()
+	release := make(chan struct{})
+	ch := group.DoChan("key", func() (int, error) {
+		<-release
+		return 1, nil
+	})
+
+	// 忘记后，新的调用不等待进行中的调用
+	group.Forget("key")
+	value, _, shared := group.Do("key", func() (int, error) {
+		return 2, nil
+	})
+	assert.Equal(t, 2, value)
+	assert.False(t, shared)
+
+	close(release)
+	assert.Equal(t, 1, (<-ch).Value)
+}
+
+func TestGroup_Panic(t *testing.T) {
+	group := NewGroup[string, int]()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var startOnce sync.Once
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() {
+				r := recover()
+				panicErr, ok := r.(*PanicError)
+				if assert.True(t, ok) {
+					assert.Equal(t, "boom", panicErr.Value)
+					assert.NotEmpty(t, panicErr.Stack)
+				}
+			}()
+
+			group.Do("key", func() (int, error) {
+				// 晚到的调用者可能在panic之后才开始，自己再执行一次
+				startOnce.Do(func() {
+					close(started)
+				})
+				<-release
+				panic("boom")
+			})
+		}(i)
+		if i == 0 {
+			<-started
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// panic的结果不缓存
+	value, err, _ := group.Do("key", func() (int, error) {
+		return 1, nil
+	})
+	assert.Equal(t, 1, value)
+	assert.NoError(t, err)
+}
+
+func TestGroup_Cache(t *testing.T) {
+	group := NewGroupWithCache[string, int](50 * time.Millisecond)
+
+	var calls atomic.Int64
+	fn := func() (int, error) {
+		return int(calls.Add(1)), nil
+	}
+
+	value, _, shared := group.Do("key", fn)
+	assert.Equal(t, 1, value)
+	assert.False(t, shared)
+
+	// 窗口内返回缓存的结果
+	value, _, shared = group.Do("key", fn)
+	assert.Equal(t, 1, value)
+	assert.True(t, shared)
+
+	// 错误不缓存
+	_, err, _ := group.Do("failed", func() (int, error) {
+		return 0, errors.New("failed")
+	})
+	assert.Error(t, err)
+	value, err, _ = group.Do("failed", fn)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, value)
+
+	// 过期后重新执行
+	time.Sleep(80 * time.Millisecond)
+	value, _, _ = group.Do("key", fn)
+	assert.Equal(t, 3, value)
+
+	group.Forget("key")
+	value, _, _ = group.Do("key", fn)
+	assert.Equal(t, 4, value)
+}
+
+func TestGroup_Goexit(t *testing.T) {
+	group := NewGroupWithCache[string, int](time.Hour)
+	release := make(chan struct{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		group.Do("key", func() (int, error) {
+			<-release
+			runtime.Goexit()
+			return 1, nil
+		})
+	}()
+
+	// 等待者得到错误
+	for {
+		if _, ok := group.calls.Load("key"); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ch := group.DoChan("key", func() (int, error) {
+		return 2, nil
+	})
+	close(release)
+	<-done
+	result := <-ch
+	assert.Equal(t, ErrGoexit, result.Err)
+
+	// 不缓存
+	value, err, shared := group.Do("key", func() (int, error) {
+		return 3, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, value)
+	assert.False(t, shared)
+
+	// DoChan执行的函数调用runtime.Goexit，依然送达结果
+	result = <-group.DoChan("other", func() (int, error) {
+		runtime.Goexit()
+		return 4, nil
+	})
+	assert.Equal(t, ErrGoexit, result.Err)
+}
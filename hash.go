@@ -0,0 +1,120 @@
+package freesync
+
+import (
+	"hash/maphash"
+	"math"
+	"reflect"
+)
+
+// Hasher 计算键的哈希值。
+// 相等的键必须得到相同的哈希值；不相等的键允许冲突。
+type Hasher[K comparable] func(key K) uint64
+
+// hashSeed 进程内共享的哈希种子。
+var hashSeed = maphash.MakeSeed()
+
+// defaultHasher 返回K类型的默认哈希函数。
+// 常见的字符串、整数类型走快速路径，其它类型通过反射逐字段计算。
+func defaultHasher[K comparable]() Hasher[K] {
+	return func(key K) uint64 {
+		switch k := any(key).(type) {
+		case string:
+			return maphash.String(hashSeed, k)
+		case int:
+			return mixUint64(uint64(k))
+		case int32:
+			return mixUint64(uint64(k))
+		case int64:
+			return mixUint64(uint64(k))
+		case uint:
+			return mixUint64(uint64(k))
+		case uint32:
+			return mixUint64(uint64(k))
+		case uint64:
+			return mixUint64(k)
+		case uintptr:
+			return mixUint64(uint64(k))
+		}
+
+		var h maphash.Hash
+		h.SetSeed(hashSeed)
+		hashValue(&h, reflect.ValueOf(&key).Elem())
+		return h.Sum64()
+	}
+}
+
+// mixUint64 整数的混淆函数（splitmix64的最后一步）。
+// 让相邻的整数在各个位上均匀分布。
+func mixUint64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// writeUint64 以小端序写入一个整数。
+func writeUint64(h *maphash.Hash, x uint64) {
+	var buf [8]byte
+	for i := range buf {
+		buf[i] = byte(x >> (8 * i))
+	}
+	_, _ = h.Write(buf[:])
+}
+
+// hashValue 基于反射递归计算可比较值的哈希。
+// 指针、chan按地址计算，与==的语义保持一致；==不比较结构体的空白字段，这里也跳过。
+func hashValue(h *maphash.Hash, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			_ = h.WriteByte(1)
+		} else {
+			_ = h.WriteByte(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeUint64(h, uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeUint64(h, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		writeUint64(h, floatBits(v.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		writeUint64(h, floatBits(real(c)))
+		writeUint64(h, floatBits(imag(c)))
+	case reflect.String:
+		_, _ = h.WriteString(v.String())
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		writeUint64(h, uint64(v.Pointer()))
+	case reflect.Interface:
+		if v.IsNil() {
+			_ = h.WriteByte(0)
+			return
+		}
+		elem := v.Elem()
+		_, _ = h.WriteString(elem.Type().String())
+		hashValue(h, elem)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			hashValue(h, v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).Name == "_" {
+				continue
+			}
+			hashValue(h, v.Field(i))
+		}
+	default:
+		panic("unhashable type " + v.Type().String())
+	}
+}
+
+// floatBits 浮点数的哈希位。+0和-0相等，必须得到相同的结果。
+func floatBits(f float64) uint64 {
+	if f == 0 {
+		return 0
+	}
+	return math.Float64bits(f)
+}
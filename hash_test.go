@@ -0,0 +1,39 @@
+package freesync
+
+import (
+	"math"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultHasher(t *testing.T) {
+	type key struct {
+		name string
+		id   int
+		p    *int
+	}
+
+	hasher := defaultHasher[key]()
+	a, b := 1, 1
+	assert.Equal(t, hasher(key{"a", 1, &a}), hasher(key{"a", 1, &a}))
+	assert.NotEqual(t, hasher(key{"a", 1, &a}), hasher(key{"a", 1, &b}))
+	assert.NotEqual(t, hasher(key{"a", 1, nil}), hasher(key{"a", 2, nil}))
+
+	floatHasher := defaultHasher[float64]()
+	assert.Equal(t, floatHasher(0), floatHasher(math.Copysign(0, -1)))
+
+	// ==不比较空白字段，空白字段不同的键也要得到相同的哈希值
+	type blankKey struct {
+		id int
+		_  int
+	}
+	k1, k2 := blankKey{id: 1}, blankKey{id: 1}
+	*(*int)(unsafe.Add(unsafe.Pointer(&k2), unsafe.Sizeof(0))) = 2
+	assert.True(t, k1 == k2)
+	assert.Equal(t, defaultHasher[blankKey]()(k1), defaultHasher[blankKey]()(k2))
+
+	intHasher := defaultHasher[int]()
+	assert.NotEqual(t, intHasher(1), intHasher(2))
+}
@@ -0,0 +1,5 @@
+// Package cpu 处理器相关的常量。只供本模块内部使用。
+package cpu
+
+// CacheLineSize 缓存行大小。按它填充结构体，避免相邻的数据伪共享。
+const CacheLineSize = 64
@@ -0,0 +1,219 @@
+// Package epoch 基于纪元的内存回收。
+//
+// 无锁结构移除节点后，其它过程可能还持有这个节点的指针，不能立即重用。
+// 访问结构前Pin，访问结束后Unpin；移除的节点通过Retire延迟回收。
+// 全局纪元只有在所有Pin住的过程都已经进入当前纪元后才能推进，
+// 在纪元e中Retire的对象，等全局纪元推进到e+2后，就不再可能被任何过程访问，可以安全回收。
+//
+// 一个过程Pin住后长时间不Unpin，会阻止纪元推进，待回收的对象会不断累积。
+// 用NewDomainWithMaxPending限制积压的数量，超出的对象交给GC。
+package epoch
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// collectThreshold 待回收的对象达到这个数量时，尝试推进纪元并回收。
+const collectThreshold = 64
+
+const (
+	// guardPinned state的最低位。为1表示Pin住。
+	guardPinned = 1
+
+	// guardHeld state的次低位。为1表示被某个过程持有。
+	guardHeld = 2
+
+	// guardEpochShift state中纪元部分的偏移。
+	guardEpochShift = 2
+)
+
+// retired 待回收的对象。
+type retired struct {
+	// epoch Retire时的纪元。
+	epoch uint64
+
+	obj  interface{}
+	free func(obj interface{})
+}
+
+// Guard 参与者。Pin返回，Unpin后归还给Domain，供之后的Pin重用。
+type Guard struct {
+	domain *Domain
+
+	// state 由guardPinned、guardHeld和Pin住时的纪元组成。零值表示空闲。
+	// Pin和Unpin各只需要一次原子操作。
+	state atomic.Uint64
+
+	// retired 待回收的对象。只有持有者访问。
+	retired []retired
+
+	// collectAt retired达到这个数量时，才再次尝试回收。
+	// 纪元推进不了时，每次翻倍，避免每次Unpin都扫描全部积压的对象。
+	collectAt int
+
+	// dropped 上次回收之后，超出积压上限、交给GC的对象数量。
+	dropped int
+
+	// next 下一个参与者。加入链表后不再修改。
+	next *Guard
+}
+
+// Domain 回收域。零值可用。
+type Domain struct {
+	// epoch 全局纪元。
+	epoch atomic.Uint64
+
+	// guards 所有参与者。只增不减。
+	guards atomic.Pointer[Guard]
+
+	// maxPending 每个参与者最多积压的对象数量。为0表示不限制。
+	maxPending int
+}
+
+// NewDomain 新建一个回收域。
+func NewDomain() *Domain {
+	return &Domain{}
+}
+
+// NewDomainWithMaxPending 新建一个回收域，每个参与者最多积压maxPending个待回收的对象。
+// 有过程Pin住后被挂起时，纪元推进不了，超出的对象不再调用free，直接交给GC。
+// 适合free只是把对象放回空闲列表、丢掉一些也无妨的场景。
+func NewDomainWithMaxPending(maxPending int) *Domain {
+	return &Domain{maxPending: maxPending}
+}
+
+// Pin 进入临界区。临界区内读到的节点，在Unpin之前不会被回收。
+// 返回的Guard只能由当前过程使用。
+func (domain *Domain) Pin() *Guard {
+	epoch := domain.epoch.Load()
+	guard := domain.acquire(epoch<<guardEpochShift | guardHeld | guardPinned)
+	for {
+		// 确认Pin住的纪元仍是全局纪元。否则推进纪元的过程可能没有看到Pin住的状态
+		current := domain.epoch.Load()
+		if current == epoch {
+			return guard
+		}
+		epoch = current
+		guard.state.Store(epoch<<guardEpochShift | guardHeld | guardPinned)
+	}
+}
+
+// acquire 取得一个空闲的参与者，把状态设为state。没有空闲的就新建一个。
+func (domain *Domain) acquire(state uint64) *Guard {
+	for guard := domain.guards.Load(); guard != nil; guard = guard.next {
+		if guard.state.Load() == 0 && guard.state.CompareAndSwap(0, state) {
+			return guard
+		}
+	}
+
+	guard := &Guard{domain: domain}
+	guard.state.Store(state)
+	for {
+		head := domain.guards.Load()
+		guard.next = head
+		if domain.guards.CompareAndSwap(head, guard) {
+			return guard
+		}
+	}
+}
+
+// Unpin 离开临界区。之后不能再使用临界区内读到的节点，也不能再使用guard。
+func (guard *Guard) Unpin() {
+	collectAt := guard.collectAt
+	if collectAt < collectThreshold {
+		collectAt = collectThreshold
+	}
+	if len(guard.retired)+guard.dropped >= collectAt {
+		// 先解除Pin住的状态，再推进纪元
+		guard.state.Store(guardHeld)
+		guard.collect()
+	}
+	guard.state.Store(0)
+}
+
+// Retire 延迟回收obj。等到没有过程可能访问obj后，调用free(obj)。
+// obj必须已经从结构中移除，不能再被新的访问者读到。
+// free通常把对象放回空闲列表。
+// 积压的对象超出NewDomainWithMaxPending设置的上限时，不调用free，obj交给GC。
+func (guard *Guard) Retire(obj interface{}, free func(obj interface{})) {
+	if guard.domain.maxPending > 0 && len(guard.retired) >= guard.domain.maxPending {
+		guard.dropped++
+		return
+	}
+	// 记录当前的全局纪元，而不是Pin住时的纪元。Pin住时的纪元可能落后一个，
+	// 全局纪元推进到它+2时，在新纪元中Pin住、读到obj的过程可能还没有Unpin
+	guard.retired = append(guard.retired, retired{
+		epoch: guard.domain.epoch.Load(),
+		obj:   obj,
+		free:  free,
+	})
+}
+
+// collect 尝试推进纪元，回收可以回收的对象。调用者持有guard，且没有Pin住。
+func (guard *Guard) collect() {
+	// 没有其它过程Pin在旧纪元时，连续推进两次，之前Retire的对象都可以回收
+	guard.domain.tryAdvance()
+	guard.domain.tryAdvance()
+	epoch := guard.domain.epoch.Load()
+
+	remain := guard.retired[:0]
+	for _, item := range guard.retired {
+		if item.epoch+2 <= epoch {
+			item.free(item.obj)
+		} else {
+			remain = append(remain, item)
+		}
+	}
+	for i := len(remain); i < len(guard.retired); i++ {
+		guard.retired[i] = retired{}
+	}
+	guard.retired = remain
+	guard.collectAt = 2 * len(remain)
+	guard.dropped = 0
+}
+
+// tryAdvance 所有Pin住的参与者都已进入当前纪元时，推进纪元。
+func (domain *Domain) tryAdvance() bool {
+	epoch := domain.epoch.Load()
+	for guard := domain.guards.Load(); guard != nil; guard = guard.next {
+		state := guard.state.Load()
+		if state&guardPinned != 0 && state>>guardEpochShift != epoch {
+			return false
+		}
+	}
+	return domain.epoch.CompareAndSwap(epoch, epoch+1)
+}
+
+// Synchronize 等待调用前Pin住的过程全部Unpin。
+// 调用者不能处于Pin住的状态，否则永远等不到。
+func (domain *Domain) Synchronize() {
+	target := domain.epoch.Load() + 2
+	for domain.epoch.Load() < target {
+		if !domain.tryAdvance() {
+			runtime.Gosched()
+		}
+	}
+}
+
+// Collect 尝试推进纪元，回收所有空闲参与者中可以回收的对象。
+func (domain *Domain) Collect() {
+	for guard := domain.guards.Load(); guard != nil; guard = guard.next {
+		if guard.state.Load() == 0 && guard.state.CompareAndSwap(0, guardHeld) {
+			guard.collect()
+			guard.state.Store(0)
+		}
+	}
+}
+
+// Pending 待回收的对象数量。只统计空闲的参与者，用于观察和测试。
+func (domain *Domain) Pending() int {
+	var pending int
+	for guard := domain.guards.Load(); guard != nil; guard = guard.next {
+		if guard.state.Load() == 0 && guard.state.CompareAndSwap(0, guardHeld) {
+			pending += len(guard.retired)
+			guard.state.Store(0)
+		}
+	}
+	return pending
+}
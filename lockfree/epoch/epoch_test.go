@@ -0,0 +1,168 @@
+package epoch
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomain(t *testing.T) {
+	domain := NewDomain()
+
+	var freed []int
+	free := func(obj interface{}) {
+		freed = append(freed, obj.(int))
+	}
+
+	guard := domain.Pin()
+	guard.Retire(1, free)
+	guard.Unpin()
+	assert.Equal(t, 1, domain.Pending())
+
+	// 另一个过程还Pin在旧纪元，不能回收
+	reader := domain.Pin()
+	for i := 0; i < 10; i++ {
+		domain.Collect()
+	}
+	assert.Empty(t, freed)
+
+	reader.Unpin()
+	for i := 0; i < 3; i++ {
+		domain.Collect()
+	}
+	assert.Equal(t, []int{1}, freed)
+	assert.Equal(t, 0, domain.Pending())
+}
+
+func TestDomain_ReuseGuard(t *testing.T) {
+	domain := NewDomain()
+	first := domain.Pin()
+	first.Unpin()
+	second := domain.Pin()
+	assert.Same(t, first, second)
+
+	// 被持有的不能重用
+	third := domain.Pin()
+	assert.NotSame(t, second, third)
+	second.Unpin()
+	third.Unpin()
+}
+
+func TestDomain_Concurrently(t *testing.T) {
+	// 读者Pin住期间读到的对象，不能被回收
+	type object struct {
+		freed atomic.Bool
+	}
+
+	domain := NewDomain()
+	var current atomic.Pointer[object]
+	current.Store(&object{})
+
+	free := func(obj interface{}) {
+		obj.(*object).freed.Store(true)
+	}
+
+	var freedCount atomic.Int64
+	countingFree := func(obj interface{}) {
+		free(obj)
+		freedCount.Add(1)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 20000; j++ {
+				guard := domain.Pin()
+				obj := current.Load()
+				if obj.freed.Load() {
+					t.Error("read a freed object")
+				}
+				guard.Unpin()
+			}
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 5000; j++ {
+				guard := domain.Pin()
+				old := current.Swap(&object{})
+				guard.Retire(old, countingFree)
+				guard.Unpin()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 3; i++ {
+		domain.Collect()
+	}
+	assert.Equal(t, int64(4*5000), freedCount.Load())
+	assert.Equal(t, 0, domain.Pending())
+}
+
+func TestDomain_MaxPending(t *testing.T) {
+	domain := NewDomainWithMaxPending(100)
+
+	var freed int
+	free := func(obj interface{}) {
+		freed++
+	}
+
+	// 另一个过程Pin住，纪元推进不了，超出上限的对象交给GC
+	reader := domain.Pin()
+	for i := 0; i < 1000; i++ {
+		guard := domain.Pin()
+		guard.Retire(i, free)
+		guard.Unpin()
+	}
+	assert.Equal(t, 0, freed)
+	assert.Equal(t, 100, domain.Pending())
+
+	// 读者离开后，积压的对象可以回收
+	reader.Unpin()
+	domain.Collect()
+	assert.Equal(t, 100, freed)
+	assert.Equal(t, 0, domain.Pending())
+
+	// 纪元可以推进时，一次回收就清空积压
+	for i := 0; i < 1000; i++ {
+		guard := domain.Pin()
+		guard.Retire(i, free)
+		guard.Unpin()
+	}
+	assert.LessOrEqual(t, domain.Pending(), collectThreshold)
+}
+
+func TestDomain_RetireWithStalePin(t *testing.T) {
+	// 写者Pin在旧纪元，读者Pin在新纪元。写者移除的对象，要等读者Unpin后才能回收
+	domain := NewDomain()
+	var freed bool
+	free := func(obj interface{}) {
+		freed = true
+	}
+
+	writer := domain.Pin()
+	assert.True(t, domain.tryAdvance())
+	reader := domain.Pin()
+	writer.Retire(1, free)
+	writer.Unpin()
+
+	for i := 0; i < 10; i++ {
+		domain.Collect()
+	}
+	assert.False(t, freed)
+
+	reader.Unpin()
+	for i := 0; i < 3; i++ {
+		domain.Collect()
+	}
+	assert.True(t, freed)
+}
@@ -0,0 +1,180 @@
+// Package hazard 基于风险指针的内存回收。
+//
+// 访问节点前，先用Protect把节点指针登记到风险指针中，并确认节点仍在结构中。
+// 移除的节点通过Retire延迟回收。Scan时，没有被任何风险指针登记的节点就可以回收。
+//
+// 与纪元回收相比，一个过程长时间停顿，只会阻止它登记的少数节点被回收，
+// 每个Record未回收的节点数量有上限。代价是每次读取节点指针都要登记和确认。
+package hazard
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Slots 每个Record可以同时登记的风险指针数量。
+const Slots = 4
+
+// minScanThreshold 触发Scan的最少待回收节点数量。
+const minScanThreshold = 64
+
+// retired 待回收的节点。
+type retired struct {
+	p    unsafe.Pointer
+	free func(p unsafe.Pointer)
+}
+
+// Record 参与者的风险指针。Acquire返回，Release后归还给Domain，供之后的Acquire重用。
+type Record struct {
+	domain *Domain
+
+	// hazards 风险指针。只能原子访问。
+	hazards [Slots]unsafe.Pointer
+
+	// inUse 是否被某个过程持有。
+	inUse atomic.Bool
+
+	// retired 待回收的节点。只有持有者访问。
+	retired []retired
+
+	// protected Scan时收集的风险指针。重复使用，避免每次Scan都分配。
+	protected map[unsafe.Pointer]struct{}
+
+	// next 下一个Record。加入链表后不再修改。
+	next *Record
+}
+
+// Domain 回收域。零值可用。
+type Domain struct {
+	// records 所有Record。只增不减。
+	records atomic.Pointer[Record]
+
+	// count Record的数量。
+	count atomic.Int64
+}
+
+// NewDomain 新建一个回收域。
+func NewDomain() *Domain {
+	return &Domain{}
+}
+
+// Acquire 取得一个空闲的Record。没有空闲的就新建一个。
+// 返回的Record只能由当前过程使用。
+func (domain *Domain) Acquire() *Record {
+	for record := domain.records.Load(); record != nil; record = record.next {
+		if !record.inUse.Load() && record.inUse.CompareAndSwap(false, true) {
+			return record
+		}
+	}
+
+	record := &Record{domain: domain}
+	record.inUse.Store(true)
+	for {
+		head := domain.records.Load()
+		record.next = head
+		if domain.records.CompareAndSwap(head, record) {
+			domain.count.Add(1)
+			return record
+		}
+	}
+}
+
+// Release 清除所有风险指针，归还record。之后不能再使用record。
+func (record *Record) Release() {
+	for slot := range record.hazards {
+		record.Clear(slot)
+	}
+	if len(record.retired) >= record.domain.scanThreshold() {
+		record.Scan()
+	}
+	record.inUse.Store(false)
+}
+
+// Protect 读取src指向的节点，并登记到record的第slot个风险指针。
+// 在清除或者重新登记之前，返回的节点不会被回收。
+func Protect[T any](record *Record, slot int, src *atomic.Pointer[T]) *T {
+	for {
+		p := src.Load()
+		atomic.StorePointer(&record.hazards[slot], unsafe.Pointer(p))
+		// 确认登记前节点没有被移除。否则Scan可能没有看到登记
+		if src.Load() == p {
+			return p
+		}
+	}
+}
+
+// Clear 清除第slot个风险指针。
+func (record *Record) Clear(slot int) {
+	atomic.StorePointer(&record.hazards[slot], nil)
+}
+
+// Retire 延迟回收p。等到没有风险指针登记p后，调用free(p)。
+// p必须已经从结构中移除，不能再被新的访问者读到。
+func (record *Record) Retire(p unsafe.Pointer, free func(p unsafe.Pointer)) {
+	record.retired = append(record.retired, retired{p: p, free: free})
+	if len(record.retired) >= record.domain.scanThreshold() {
+		record.Scan()
+	}
+}
+
+// scanThreshold 触发Scan的待回收节点数量。
+// 取风险指针总数的两倍，每次Scan至少能回收一半。
+func (domain *Domain) scanThreshold() int {
+	threshold := 2 * Slots * int(domain.count.Load())
+	if threshold < minScanThreshold {
+		threshold = minScanThreshold
+	}
+	return threshold
+}
+
+// Scan 回收record中没有被登记的节点。
+func (record *Record) Scan() {
+	if record.protected == nil {
+		record.protected = make(map[unsafe.Pointer]struct{})
+	}
+	for p := range record.protected {
+		delete(record.protected, p)
+	}
+	for other := record.domain.records.Load(); other != nil; other = other.next {
+		for slot := range other.hazards {
+			if p := atomic.LoadPointer(&other.hazards[slot]); p != nil {
+				record.protected[p] = struct{}{}
+			}
+		}
+	}
+
+	remain := record.retired[:0]
+	for _, item := range record.retired {
+		if _, ok := record.protected[item.p]; ok {
+			remain = append(remain, item)
+		} else {
+			item.free(item.p)
+		}
+	}
+	for i := len(remain); i < len(record.retired); i++ {
+		record.retired[i] = retired{}
+	}
+	record.retired = remain
+}
+
+// Scan 回收所有空闲Record中没有被登记的节点。
+func (domain *Domain) Scan() {
+	for record := domain.records.Load(); record != nil; record = record.next {
+		if !record.inUse.Load() && record.inUse.CompareAndSwap(false, true) {
+			record.Scan()
+			record.inUse.Store(false)
+		}
+	}
+}
+
+// Pending 待回收的节点数量。只统计空闲的Record，用于观察和测试。
+func (domain *Domain) Pending() int {
+	var pending int
+	for record := domain.records.Load(); record != nil; record = record.next {
+		if !record.inUse.Load() && record.inUse.CompareAndSwap(false, true) {
+			pending += len(record.retired)
+			record.inUse.Store(false)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,97 @@
+package hazard
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type object struct {
+	freed atomic.Bool
+}
+
+func freeObject(p unsafe.Pointer) {
+	(*object)(p).freed.Store(true)
+}
+
+func TestDomain(t *testing.T) {
+	domain := NewDomain()
+	var current atomic.Pointer[object]
+	current.Store(&object{})
+
+	reader := domain.Acquire()
+	protected := Protect(reader, 0, &current)
+
+	writer := domain.Acquire()
+	old := current.Swap(&object{})
+	assert.Same(t, protected, old)
+	writer.Retire(unsafe.Pointer(old), freeObject)
+	writer.Release()
+
+	// 仍被登记，不能回收
+	domain.Scan()
+	assert.False(t, old.freed.Load())
+	assert.Equal(t, 1, domain.Pending())
+
+	reader.Clear(0)
+	domain.Scan()
+	assert.True(t, old.freed.Load())
+	assert.Equal(t, 0, domain.Pending())
+	reader.Release()
+}
+
+func TestDomain_StalledReader(t *testing.T) {
+	// 一个过程登记后停顿，其它节点照常回收，未回收的节点数量有上限
+	domain := NewDomain()
+	var current atomic.Pointer[object]
+	current.Store(&object{})
+
+	stalled := domain.Acquire()
+	protected := Protect(stalled, 0, &current)
+
+	var retired, freed atomic.Int64
+	countingFree := func(p unsafe.Pointer) {
+		freeObject(p)
+		freed.Add(1)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 20000; j++ {
+				record := domain.Acquire()
+				obj := Protect(record, 0, &current)
+				if obj.freed.Load() {
+					t.Error("read a freed object")
+				}
+				if current.CompareAndSwap(obj, &object{}) {
+					record.Clear(0)
+					record.Retire(unsafe.Pointer(obj), countingFree)
+					retired.Add(1)
+				}
+				record.Release()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 每个Record最多保留一轮Scan的阈值
+	bound := int64(domain.count.Load()) * int64(domain.scanThreshold())
+	assert.True(t, retired.Load()-freed.Load() <= bound, "unreclaimed: %d, bound: %d", retired.Load()-freed.Load(), bound)
+	assert.False(t, protected.freed.Load())
+
+	domain.Scan()
+	assert.Equal(t, 1, domain.Pending())
+	assert.Equal(t, retired.Load()-1, freed.Load())
+
+	stalled.Release()
+	domain.Scan()
+	assert.Equal(t, 0, domain.Pending())
+	assert.True(t, protected.freed.Load())
+}
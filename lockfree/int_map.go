@@ -0,0 +1,443 @@
+package lockfree
+
+import (
+	"sync/atomic"
+)
+
+// 参考：Cliff Click. A Lock-Free Hash Table.
+
+const (
+	// intMapEmptyKey 空的键槽。
+	intMapEmptyKey uint64 = 0
+
+	// intMapClosedKey 复制时关闭的空键槽。阻止新的键插入旧表。
+	intMapClosedKey uint64 = 1
+
+	// intMapMinCapacity 表的最小容量。
+	intMapMinCapacity = 8
+
+	// intMapCopyChunk 每次协助复制的槽数量。
+	intMapCopyChunk = 1024
+)
+
+// intMapValue 值槽中的值。
+// 值槽为nil表示空；deleted表示墓碑；primed表示正在复制到新表，旧表中的值不能再修改。
+// deleted且primed，表示该槽已经复制完成。
+type intMapValue[V any] struct {
+	value   V
+	deleted bool
+	primed  bool
+
+	// origin primed值包装的原值。复制到新表时直接使用，避免再分配。
+	origin *intMapValue[V]
+}
+
+// live 是否是存活的值。
+func (v *intMapValue[V]) live() bool {
+	return v != nil && !v.deleted
+}
+
+// intMapTable 开放寻址的表。
+// 扩容时，新建一个next表，所有过程协助把旧表的槽逐个复制到新表。复制完成后，新表替换旧表。
+type intMapTable[V any] struct {
+	keys   []atomic.Uint64
+	values []atomic.Pointer[intMapValue[V]]
+	mask   uint64
+
+	// slots 已占用的键槽数量。键槽一经占用，不再释放。
+	slots atomic.Int64
+
+	// next 扩容的目标表。
+	next atomic.Pointer[intMapTable[V]]
+
+	// copyIndex 下一个待复制的块的起始位置。
+	copyIndex atomic.Int64
+
+	// copied 已复制完成的槽数量。
+	copied atomic.Int64
+}
+
+// newIntMapTable 新建一个表。capacity必须是2的幂。
+func newIntMapTable[V any](capacity int) *intMapTable[V] {
+	return &intMapTable[V]{
+		keys:   make([]atomic.Uint64, capacity),
+		values: make([]atomic.Pointer[intMapValue[V]], capacity),
+		mask:   uint64(capacity - 1),
+	}
+}
+
+// reprobeLimit 最大探测次数。超过后需要扩容。
+func (table *intMapTable[V]) reprobeLimit() int {
+	return 10 + len(table.keys)/4
+}
+
+// IntMap 无锁的uint64键哈希表。线性探测，支持并发的渐进式扩容。
+type IntMap[V any] struct {
+	table atomic.Pointer[intMapTable[V]]
+
+	// zero、one 键0和1与槽的特殊状态冲突，单独存放。
+	zero atomic.Pointer[intMapValue[V]]
+	one  atomic.Pointer[intMapValue[V]]
+
+	// size 存活的键数量。
+	size atomic.Int64
+
+	// tombstone、tombPrime 共享的墓碑，和复制完成的标记。
+	tombstone *intMapValue[V]
+	tombPrime *intMapValue[V]
+}
+
+// NewIntMap 新建一个IntMap。
+func NewIntMap[V any]() *IntMap[V] {
+	m := &IntMap[V]{
+		tombstone: &intMapValue[V]{deleted: true},
+		tombPrime: &intMapValue[V]{deleted: true, primed: true},
+	}
+	m.table.Store(newIntMapTable[V](intMapMinCapacity))
+	return m
+}
+
+// Load 取得键对应的值。
+func (m *IntMap[V]) Load(key uint64) (value V, ok bool) {
+	var v *intMapValue[V]
+	if special := m.special(key); special != nil {
+		v = special.Load()
+	} else {
+		v = m.get(m.table.Load(), key)
+	}
+	if v.live() {
+		return v.value, true
+	}
+	return value, false
+}
+
+// Store 设置键对应的值。
+func (m *IntMap[V]) Store(key uint64, value V) {
+	m.put(key, &intMapValue[V]{value: value}, false)
+}
+
+// LoadOrStore 如果键存在，返回已有的值，loaded为true；否则保存并返回value。
+func (m *IntMap[V]) LoadOrStore(key uint64, value V) (actual V, loaded bool) {
+	old := m.put(key, &intMapValue[V]{value: value}, true)
+	if old.live() {
+		return old.value, true
+	}
+	return value, false
+}
+
+// Delete 删除键。
+func (m *IntMap[V]) Delete(key uint64) {
+	m.LoadAndDelete(key)
+}
+
+// LoadAndDelete 删除键，返回删除前的值。
+func (m *IntMap[V]) LoadAndDelete(key uint64) (value V, loaded bool) {
+	old := m.put(key, m.tombstone, false)
+	if old.live() {
+		return old.value, true
+	}
+	return value, false
+}
+
+// Range 遍历。不是一致的快照：并发修改可能可见，也可能不可见。
+func (m *IntMap[V]) Range(f func(key uint64, value V) (stopIteration bool)) {
+	for key, special := range []*atomic.Pointer[intMapValue[V]]{&m.zero, &m.one} {
+		if v := special.Load(); v.live() && f(uint64(key), v.value) {
+			return
+		}
+	}
+
+	// 协助复制完进行中的扩容，之后全部的值都在最新的表中。
+	// 否则复制没完成时，已经插入新表的键遍历不到
+	table := m.table.Load()
+	for {
+		next := table.next.Load()
+		if next == nil {
+			break
+		}
+		for index := range table.keys {
+			m.copySlotAndCheck(table, uint64(index), false)
+		}
+		table = next
+	}
+
+	for index := range table.keys {
+		key := table.keys[index].Load()
+		if key == intMapEmptyKey || key == intMapClosedKey {
+			continue
+		}
+		v := table.values[index].Load()
+		if v != nil && v.primed {
+			// 已经复制到新表
+			v = m.get(table, key)
+		}
+		if v.live() && f(key, v.value) {
+			return
+		}
+	}
+}
+
+// Length 长度。
+func (m *IntMap[V]) Length() int {
+	return int(m.size.Load())
+}
+
+// special 键0和1的存放位置。
+func (m *IntMap[V]) special(key uint64) *atomic.Pointer[intMapValue[V]] {
+	switch key {
+	case 0:
+		return &m.zero
+	case 1:
+		return &m.one
+	default:
+		return nil
+	}
+}
+
+// put 保存键值。onlyIfAbsent为true时，不覆盖存活的值。返回旧值。
+func (m *IntMap[V]) put(key uint64, putValue *intMapValue[V], onlyIfAbsent bool) (old *intMapValue[V]) {
+	if special := m.special(key); special != nil {
+		for {
+			old = special.Load()
+			if (onlyIfAbsent && old.live()) || (putValue.deleted && !old.live()) {
+				return old
+			}
+			if special.CompareAndSwap(old, putValue) {
+				m.adjustSize(old, putValue)
+				return old
+			}
+		}
+	}
+
+	old = m.putIfMatch(m.table.Load(), key, putValue, onlyIfAbsent, false)
+	m.adjustSize(old, putValue)
+	return old
+}
+
+// adjustSize 根据新旧值调整长度。
+func (m *IntMap[V]) adjustSize(old, putValue *intMapValue[V]) {
+	switch {
+	case !old.live() && putValue.live():
+		m.size.Add(1)
+	case old.live() && !putValue.live():
+		m.size.Add(-1)
+	}
+}
+
+// get 在表中查找键。
+func (m *IntMap[V]) get(table *intMapTable[V], key uint64) *intMapValue[V] {
+	index := intMapHash(key) & table.mask
+	reprobes := 0
+	for {
+		k := table.keys[index].Load()
+		v := table.values[index].Load()
+		if k == intMapEmptyKey {
+			// 键不在表中。扩容期间新的键也一定先占用旧表的键槽。
+			return nil
+		}
+
+		next := table.next.Load()
+		if k == key {
+			if v == nil || !v.primed {
+				return v
+			}
+			// 已经或正在复制到新表，到新表中查找
+			return m.get(m.copySlotAndCheck(table, index, false), key)
+		}
+
+		reprobes++
+		if reprobes >= table.reprobeLimit() || k == intMapClosedKey {
+			if next == nil {
+				return nil
+			}
+			return m.get(m.helpCopy(next), key)
+		}
+		index = (index + 1) & table.mask
+	}
+}
+
+// putIfMatch 在表中保存键值，返回旧值。
+// copying为true时，表示从旧表复制值，只在新表中没有值时写入。
+func (m *IntMap[V]) putIfMatch(table *intMapTable[V], key uint64, putValue *intMapValue[V], onlyIfAbsent, copying bool) *intMapValue[V] {
+	index := intMapHash(key) & table.mask
+	reprobes := 0
+	for {
+		k := table.keys[index].Load()
+		if k == intMapEmptyKey {
+			if putValue.deleted {
+				// 删除不存在的键
+				return nil
+			}
+			if table.keys[index].CompareAndSwap(intMapEmptyKey, key) {
+				table.slots.Add(1)
+				break
+			}
+			k = table.keys[index].Load()
+		}
+		if k == key {
+			break
+		}
+
+		reprobes++
+		if reprobes >= table.reprobeLimit() || k == intMapClosedKey {
+			// 表满了，或者正在复制。转到新表
+			next := m.resize(table)
+			if !copying {
+				m.helpCopy(next)
+			}
+			return m.putIfMatch(next, key, putValue, onlyIfAbsent, copying)
+		}
+		index = (index + 1) & table.mask
+	}
+
+	// 找到了键槽
+	for {
+		old := table.values[index].Load()
+		next := table.next.Load()
+		if next == nil && ((old == nil && m.tableFull(table, reprobes)) || (old != nil && old.primed)) {
+			next = m.resize(table)
+		}
+		if next != nil {
+			// 正在扩容。先把这个槽复制到新表，再写入新表
+			return m.putIfMatch(m.copySlotAndCheck(table, index, !copying), key, putValue, onlyIfAbsent, copying)
+		}
+
+		if copying && old != nil {
+			return old
+		}
+		if onlyIfAbsent && old.live() {
+			return old
+		}
+		if putValue.deleted && !old.live() {
+			return old
+		}
+		if table.values[index].CompareAndSwap(old, putValue) {
+			return old
+		}
+		// 值被并发修改。重试
+	}
+}
+
+// tableFull 表是否需要扩容。
+func (m *IntMap[V]) tableFull(table *intMapTable[V], reprobes int) bool {
+	return reprobes >= 10 || table.slots.Load() >= int64(len(table.keys))/2
+}
+
+// resize 开始扩容，返回新表。
+func (m *IntMap[V]) resize(table *intMapTable[V]) *intMapTable[V] {
+	if next := table.next.Load(); next != nil {
+		return next
+	}
+
+	// 存活的键较多时扩大容量；否则只是清理墓碑
+	capacity := len(table.keys)
+	size := m.size.Load()
+	if size >= int64(capacity)/4 {
+		capacity *= 2
+		if size >= int64(capacity)/4 {
+			capacity *= 2
+		}
+	}
+
+	next := newIntMapTable[V](capacity)
+	if table.next.CompareAndSwap(nil, next) {
+		return next
+	}
+	return table.next.Load()
+}
+
+// helpCopy 协助复制当前顶层表的一个块。返回helper，方便调用者继续使用。
+// 块可以被领取两遍，第二遍补上被挂起的过程没复制完的槽。
+// 不等待其它过程：剩下的槽由访问到它的过程复制，或者由挂起的过程恢复后复制。
+func (m *IntMap[V]) helpCopy(helper *intMapTable[V]) *intMapTable[V] {
+	table := m.table.Load()
+	next := table.next.Load()
+	if next == nil {
+		return helper
+	}
+
+	length := int64(len(table.keys))
+	chunk := int64(intMapCopyChunk)
+	if chunk > length {
+		chunk = length
+	}
+	start := table.copyIndex.Load()
+	for start < length*2 && !table.copyIndex.CompareAndSwap(start, start+chunk) {
+		start = table.copyIndex.Load()
+	}
+	if start < length*2 {
+		for i := int64(0); i < chunk; i++ {
+			m.copySlotAndCheck(table, uint64(start+i)&table.mask, false)
+		}
+	}
+	return helper
+}
+
+// copySlotAndCheck 复制一个槽，返回新表。
+// 所有的槽都复制完成后，新表替换旧表。
+func (m *IntMap[V]) copySlotAndCheck(table *intMapTable[V], index uint64, help bool) *intMapTable[V] {
+	next := table.next.Load()
+	if m.copySlot(table, next, index) {
+		if table.copied.Add(1) == int64(len(table.keys)) {
+			m.table.CompareAndSwap(table, next)
+		}
+	} else if table.copied.Load() == int64(len(table.keys)) {
+		// 嵌套扩容时，表可能在成为顶层之前就已复制完
+		m.table.CompareAndSwap(table, next)
+	}
+	if help {
+		return m.helpCopy(next)
+	}
+	return next
+}
+
+// copySlot 把旧表的一个槽复制到新表。返回是否由本过程完成了这个槽。
+func (m *IntMap[V]) copySlot(table, next *intMapTable[V], index uint64) bool {
+	// 关闭空的键槽，阻止新的键插入旧表
+	key := table.keys[index].Load()
+	for key == intMapEmptyKey {
+		table.keys[index].CompareAndSwap(intMapEmptyKey, intMapClosedKey)
+		key = table.keys[index].Load()
+	}
+
+	// 把旧值标记为primed，阻止旧表中的值再被修改
+	old := table.values[index].Load()
+	for old == nil || !old.primed {
+		box := m.tombPrime
+		if old.live() {
+			box = &intMapValue[V]{value: old.value, primed: true, origin: old}
+		}
+		if table.values[index].CompareAndSwap(old, box) {
+			if box.deleted {
+				// 没有需要复制的值
+				return true
+			}
+			old = box
+			break
+		}
+		old = table.values[index].Load()
+	}
+	if old.deleted {
+		// 其它过程已经复制完成
+		return false
+	}
+
+	// 复制到新表。新表中已有值时，说明之后有更新，不覆盖
+	copied := m.putIfMatch(next, key, old.origin, false, true) == nil
+
+	// 旧表中的值永久失效
+	for !old.deleted && !table.values[index].CompareAndSwap(old, m.tombPrime) {
+		old = table.values[index].Load()
+	}
+	return copied
+}
+
+// intMapHash 键的哈希。
+func intMapHash(key uint64) uint64 {
+	key ^= key >> 33
+	key *= 0xff51afd7ed558ccd
+	key ^= key >> 33
+	key *= 0xc4ceb9fe1a85ec53
+	key ^= key >> 33
+	return key
+}
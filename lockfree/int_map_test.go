@@ -0,0 +1,156 @@
+package lockfree
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntMap(t *testing.T) {
+	m := NewIntMap[int]()
+
+	_, ok := m.Load(100)
+	assert.False(t, ok)
+
+	// 包括特殊的键0和1
+	for i := 0; i < 10000; i++ {
+		m.Store(uint64(i), i)
+	}
+	assert.Equal(t, 10000, m.Length())
+	for i := 0; i < 10000; i++ {
+		value, ok := m.Load(uint64(i))
+		if !assert.True(t, ok) || !assert.Equal(t, i, value) {
+			t.FailNow()
+		}
+	}
+
+	actual, loaded := m.LoadOrStore(1, 100)
+	assert.True(t, loaded)
+	assert.Equal(t, 1, actual)
+	actual, loaded = m.LoadOrStore(20000, 20000)
+	assert.False(t, loaded)
+	assert.Equal(t, 20000, actual)
+
+	for i := 0; i < 10000; i += 2 {
+		value, loaded := m.LoadAndDelete(uint64(i))
+		assert.True(t, loaded)
+		assert.Equal(t, i, value)
+	}
+	_, loaded = m.LoadAndDelete(0)
+	assert.False(t, loaded)
+	assert.Equal(t, 5001, m.Length())
+
+	var keys []int
+	m.Range(func(key uint64, value int) (stopIteration bool) {
+		assert.Equal(t, int(key), value)
+		keys = append(keys, value)
+		return false
+	})
+	assert.Equal(t, 5001, len(keys))
+}
+
+func TestIntMap_RangeDuringResize(t *testing.T) {
+	// 扩容每次只协助复制一块，可能一直没有完成。遍历也要看到已经插入新表的键
+	m := NewIntMap[int]()
+	for i := 0; i < 200000; i++ {
+		m.Store(uint64(i), i)
+
+		if i%9973 == 0 || i == 135691 {
+			var count int
+			m.Range(func(key uint64, value int) (stopIteration bool) {
+				count++
+				return false
+			})
+			if !assert.Equal(t, m.Length(), count, "after %d stores", i+1) {
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestIntMap_Tombstones(t *testing.T) {
+	// 反复添加删除不同的键，墓碑不会让表无限增长
+	m := NewIntMap[int]()
+	for i := 2; i < 100000; i++ {
+		m.Store(uint64(i), i)
+		m.Delete(uint64(i))
+	}
+	assert.Equal(t, 0, m.Length())
+	assert.True(t, len(m.table.Load().keys) <= 64, "capacity: %d", len(m.table.Load().keys))
+}
+
+func TestIntMap_ConcurrentlyUpdate(t *testing.T) {
+	// 一组顺序的数字，并发随机写入，过程中不断扩容
+	big := 200000
+	m := NewIntMap[int]()
+
+	rand.Seed(time.Now().UnixNano())
+	ch := make(chan int, big)
+	for _, num := range rand.Perm(big) {
+		ch <- num
+	}
+	close(ch)
+
+	var wg sync.WaitGroup
+	wg.Add(50)
+	for i := 0; i < 50; i++ {
+		go func() {
+			defer wg.Done()
+
+			for num := range ch {
+				m.Store(uint64(num), num)
+				value, ok := m.Load(uint64(num))
+				if !ok || value != num {
+					assert.Equal(t, num, value)
+				}
+				if num%2 == 1 {
+					m.Delete(uint64(num))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, big/2, m.Length())
+	var all []int
+	m.Range(func(key uint64, value int) (stopIteration bool) {
+		all = append(all, value)
+		return false
+	})
+	sort.Ints(all)
+	want := make([]int, 0, big/2)
+	for i := 0; i < big; i += 2 {
+		want = append(want, i)
+	}
+	assert.Equal(t, want, all)
+}
+
+func TestIntMap_ConcurrentlyLoadOrStore(t *testing.T) {
+	// 同一个键只有一个过程能存入
+	m := NewIntMap[int]()
+	big := 10000
+
+	var stored int64
+	var wg sync.WaitGroup
+	wg.Add(20)
+	for i := 0; i < 20; i++ {
+		go func(i int) {
+			defer wg.Done()
+
+			for key := 0; key < big; key++ {
+				if _, loaded := m.LoadOrStore(uint64(key), i); !loaded {
+					atomic.AddInt64(&stored, 1)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(big), stored)
+	assert.Equal(t, big, m.Length())
+}
@@ -1,45 +1,184 @@
 package lockfree
 
 import (
+	"runtime"
 	"sync/atomic"
+	"unsafe"
+
+	"github.com/wencan/freesync/internal/cpu"
+	"github.com/wencan/freesync/lockfree/epoch"
 )
 
 // LimitedSliceEntry 包装要保存的数据。
-// LimitedSliceEntry指针为nil，表示还未初始化；LimitedSliceEntry.p为nil，表示用户存了一个数据nil。
-// atomic.Value不支持存nil，不支持更新不同类型的值，使用LimitedSliceEntry，绕过了atomic.Value的限制。
+// 槽内的指针为nil，表示还未写入；LimitedSliceEntry.p为nil，表示用户存了一个数据nil。
+// 发布到槽中后不再修改，直到被替换、经纪元回收后才重用。
 type LimitedSliceEntry struct {
 	p interface{}
+
+	// next 空闲列表中的下一个entry。
+	next atomic.Pointer[LimitedSliceEntry]
+}
+
+// limitedSliceMaxPending 一个参与者最多积压的entry数量。
+// 有读取过程Pin住后被挂起时，纪元推进不了，超出的entry交给GC。
+const limitedSliceMaxPending = 1024
+
+// limitedSliceDomain 所有LimitedSlice共用的纪元回收域。
+// 读取槽时Pin住，被替换的entry等到没有读取过程可能持有它之后，才放回limitedSliceFreeEntries重用，
+// UpdateAt不需要分配新的entry。
+var limitedSliceDomain = epoch.NewDomainWithMaxPending(limitedSliceMaxPending)
+
+// limitedSliceFreeEntries 回收的entry组成的无锁栈。
+// 只在Pin住时出栈：出栈过程读到的栈顶entry，在它Unpin之前不会被再次回收、入栈，不会出现ABA问题。
+var limitedSliceFreeEntries atomic.Pointer[LimitedSliceEntry]
+
+// newLimitedSliceEntry 新建entry，优先重用回收的entry。调用者必须Pin住。
+func newLimitedSliceEntry(p interface{}) *LimitedSliceEntry {
+	for {
+		entry := limitedSliceFreeEntries.Load()
+		if entry == nil {
+			return &LimitedSliceEntry{p: p}
+		}
+		if limitedSliceFreeEntries.CompareAndSwap(entry, entry.next.Load()) {
+			entry.next.Store(nil)
+			entry.p = p
+			return entry
+		}
+	}
+}
+
+// freeLimitedSliceEntry 把回收的entry放回limitedSliceFreeEntries。
+func freeLimitedSliceEntry(obj interface{}) {
+	entry := obj.(*LimitedSliceEntry)
+	entry.p = nil
+	for {
+		head := limitedSliceFreeEntries.Load()
+		entry.next.Store(head)
+		if limitedSliceFreeEntries.CompareAndSwap(head, entry) {
+			return
+		}
+	}
+}
+
+// limitedSliceSlot 存储槽。读取、写入都是对entry指针的原子操作，读取不等待其它过程。
+type limitedSliceSlot struct {
+	entry atomic.Pointer[LimitedSliceEntry]
+}
+
+// load 读取槽内的值。如果还未写入，返回false。调用者必须Pin住。
+func (slot *limitedSliceSlot) load() (p interface{}, ok bool) {
+	if entry := slot.entry.Load(); entry != nil {
+		return entry.p, true
+	}
+	return nil, false
+}
+
+// store 第一次写入。调用者必须Pin住。
+func (slot *limitedSliceSlot) store(p interface{}) {
+	slot.entry.Store(newLimitedSliceEntry(p))
+}
+
+// update 替换已经写入的值，返回旧值。如果还未写入，返回false，不写入。调用者必须Pin住。
+func (slot *limitedSliceSlot) update(guard *epoch.Guard, p interface{}) (old interface{}, ok bool) {
+	entry := slot.entry.Load()
+	if entry == nil {
+		return nil, false
+	}
+	newEntry := newLimitedSliceEntry(p)
+	for !slot.entry.CompareAndSwap(entry, newEntry) {
+		entry = slot.entry.Load()
+		if entry == nil {
+			// 新entry没有发布过，但可能被其它出栈过程读到过，同样要经过纪元回收
+			guard.Retire(newEntry, freeLimitedSliceEntry)
+			return nil, false
+		}
+	}
+	old = entry.p
+	guard.Retire(entry, freeLimitedSliceEntry)
+	return old, true
+}
+
+// reset 恢复为未写入的状态。调用者必须Pin住。
+func (slot *limitedSliceSlot) reset(guard *epoch.Guard) {
+	if entry := slot.entry.Swap(nil); entry != nil {
+		guard.Retire(entry, freeLimitedSliceEntry)
+	}
+}
+
+// paddedLimitedSliceSlot 独占一个缓存行的存储槽。
+// 相邻下标的并发写入不会互相使对方的缓存行失效。
+type paddedLimitedSliceSlot struct {
+	limitedSliceSlot
+	_ [cpu.CacheLineSize - unsafe.Sizeof(limitedSliceSlot{})]byte
+}
+
+// backoff 自旋一段时间后让出处理器。Seal用它等待已经取得下标的Append写入完成。
+func backoff(attempt int) {
+	if attempt >= 16 {
+		runtime.Gosched()
+	}
 }
 
 // LimitedSlice 长度受限的Slice。
+// Load、UpdateAt、Range都是无锁的，读取不等待写入。
+// UpdateAt替换下来的entry经纪元回收后重用，稳定后不分配内存。
 type LimitedSlice struct {
-	// array 值为*LimitedSliceEntry。LimitedSliceEntry内p存的是保存的数据。
-	array []atomic.Value
+	// array 紧凑的存储槽。
+	array []limitedSliceSlot
 
-	// entites 预分配的LimitedSliceEntry
-	entites []LimitedSliceEntry
-
-	// capacity 容量。
-	// 容量不会发生变化。
-	capacity int
+	// paddedArray 每个槽独占一个缓存行。array和paddedArray只有一个非空。
+	// 容量就是槽的数量，不会发生变化。
+	paddedArray []paddedLimitedSliceSlot
 
 	// nextAppendIndex 下次append元素的位置。无并发场景下，等于长度。
-	nextAppendIndex uint64
+	// 紧凑布局指向appendIndex；填充布局指向独占缓存行的计数器，与只读字段隔开。
+	nextAppendIndex *atomic.Uint64
+
+	// appendIndex 紧凑布局的nextAppendIndex。
+	appendIndex atomic.Uint64
+}
+
+// paddedAppendIndex 独占一个缓存行的nextAppendIndex。
+// 单独分配，64字节的对象按64字节对齐。
+type paddedAppendIndex struct {
+	value atomic.Uint64
+	_     [cpu.CacheLineSize - unsafe.Sizeof(atomic.Uint64{})]byte
 }
 
 // NewLimitedSlice 新建一个长度受限的Slice。
 func NewLimitedSlice(capacity int) *LimitedSlice {
+	slice := &LimitedSlice{
+		array: make([]limitedSliceSlot, capacity),
+	}
+	slice.nextAppendIndex = &slice.appendIndex
+	return slice
+}
+
+// NewPaddedLimitedSlice 新建一个长度受限的Slice，每个元素和追加位置都独占一个缓存行。
+// 适合多个过程频繁更新相邻下标的场景。内存占用是紧凑布局的两倍多。
+func NewPaddedLimitedSlice(capacity int) *LimitedSlice {
 	return &LimitedSlice{
-		array:           make([]atomic.Value, capacity),
-		entites:         make([]LimitedSliceEntry, capacity),
-		capacity:        capacity,
-		nextAppendIndex: 0,
+		paddedArray:     make([]paddedLimitedSliceSlot, capacity),
+		nextAppendIndex: &new(paddedAppendIndex).value,
+	}
+}
+
+// Padded 是否每个元素独占一个缓存行。
+func (slice *LimitedSlice) Padded() bool {
+	return slice.paddedArray != nil
+}
+
+// slot 下标位置上的存储槽。
+func (slice *LimitedSlice) slot(index int) *limitedSliceSlot {
+	if slice.paddedArray != nil {
+		return &slice.paddedArray[index].limitedSliceSlot
 	}
+	return &slice.array[index]
 }
 
 // Capacity 容量。
 func (slice *LimitedSlice) Capacity() int {
-	return slice.capacity
+	return len(slice.array) + len(slice.paddedArray)
 }
 
 // Append 追加新元素。
@@ -47,50 +186,105 @@ func (slice *LimitedSlice) Capacity() int {
 // 如果已满，返回false。
 func (slice *LimitedSlice) Append(p interface{}) (int, bool) {
 	for {
-		index := atomic.LoadUint64(&slice.nextAppendIndex)
-		if index+1 > uint64(slice.capacity) {
+		index := slice.nextAppendIndex.Load()
+		if index+1 > uint64(slice.Capacity()) {
+			// 已满，或者已封闭
 			return 0, false
 		}
 
-		if atomic.CompareAndSwapUint64(&slice.nextAppendIndex, index, index+1) {
+		if slice.nextAppendIndex.CompareAndSwap(index, index+1) {
 			// 这里需要警惕，length增长了，但数据还没存进去。
-			// 等到Store完成，才算Append结束。
-			entry := &slice.entites[index]
-			entry.p = p
-			slice.array[index].Store(entry)
+			// 等到store完成，才算Append结束。
+			guard := limitedSliceDomain.Pin()
+			slice.slot(int(index)).store(p)
+			guard.Unpin()
 			return int(index), true
 		}
 	}
 }
 
+// sealedBit nextAppendIndex的最高位。为1表示已封闭。
+const sealedBit = 1 << 63
+
+// Seal 封闭。之后的Append都会失败。返回封闭时的长度。
+// 等到已经取得下标的Append都写入完成后返回。
+func (slice *LimitedSlice) Seal() (length int) {
+	var claimed uint64
+	for {
+		index := slice.nextAppendIndex.Load()
+		if slice.nextAppendIndex.CompareAndSwap(index, index|sealedBit) {
+			claimed = index &^ sealedBit
+			break
+		}
+	}
+
+	for index := 0; index < int(claimed); index++ {
+		for attempt := 0; ; attempt++ {
+			if slice.slot(index).entry.Load() != nil {
+				break
+			}
+			backoff(attempt)
+		}
+	}
+	return int(claimed)
+}
+
+// Truncate 截断为length个元素，并重新开放Append。
+// 必须先Seal，且不能与UpdateAt截掉的元素并发。
+func (slice *LimitedSlice) Truncate(length int) {
+	index := slice.nextAppendIndex.Load()
+	if index&sealedBit == 0 {
+		panic("truncate unsealed slice")
+	}
+	claimed := int(index &^ sealedBit)
+	if length > claimed {
+		panic("truncate beyond length")
+	}
+
+	guard := limitedSliceDomain.Pin()
+	for i := length; i < claimed; i++ {
+		slice.slot(i).reset(guard)
+	}
+	guard.Unpin()
+	slice.nextAppendIndex.Store(uint64(length))
+}
+
 // Load 根据下标取回一个元素。
 func (slice *LimitedSlice) Load(index int) interface{} {
-	entry := slice.array[index].Load().(*LimitedSliceEntry)
-	return entry.p
+	guard := limitedSliceDomain.Pin()
+	p, _ := slice.slot(index).load()
+	guard.Unpin()
+	return p
 }
 
 // UpdateAt 更新下标位置上的元素，返回旧值。
+// 下标位置上还没有写入元素时panic。
+// 重用回收的entry，稳定后不分配内存。
 func (slice *LimitedSlice) UpdateAt(index int, p interface{}) (old interface{}) {
-	newEntry := &LimitedSliceEntry{p: p}
-	oldVal := slice.array[index].Swap(newEntry)
-	// 不能回收Swap返回的entry。
-	// 因为可能另一个过程刚刚拿到这个entry。
-	oldEntry := oldVal.(*LimitedSliceEntry)
-	return oldEntry.p
+	guard := limitedSliceDomain.Pin()
+	old, ok := slice.slot(index).update(guard, p)
+	guard.Unpin()
+	if !ok {
+		panic("update unwritten element")
+	}
+	return old
 }
 
 // Range 遍历。
+// 整个遍历期间Pin住，f执行得久，被替换的entry就晚一些回收，超出积压上限的交给GC。
 func (slice *LimitedSlice) Range(f func(index int, p interface{}) (stopIteration bool)) {
-	length := int(atomic.LoadUint64(&slice.nextAppendIndex))
+	guard := limitedSliceDomain.Pin()
+	defer guard.Unpin()
+
+	length := int(slice.nextAppendIndex.Load() &^ sealedBit)
 	for index := 0; index < length; index++ {
-		val := slice.array[index].Load()
-		if val == nil {
+		p, ok := slice.slot(index).load()
+		if !ok {
 			// nextAppendIndex增长了，但数据还没存进去
 			continue
 		}
 
-		entry := val.(*LimitedSliceEntry)
-		stopIteration := f(index, entry.p)
+		stopIteration := f(index, p)
 		if stopIteration {
 			break
 		}
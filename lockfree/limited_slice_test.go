@@ -2,11 +2,17 @@ package lockfree
 
 import (
 	"math/rand"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/wencan/freesync/internal/cpu"
 )
 
 func TestLimitedSlice(t *testing.T) {
@@ -203,3 +209,267 @@ func TestLimitedSlice_concurrentlyAppendLoadUpdateRange(t *testing.T) {
 		return false
 	})
 }
+
+func TestLimitedSlice_UpdateAtNil(t *testing.T) {
+	slice := NewLimitedSlice(2)
+	slice.Append(nil)
+	slice.Append(1)
+
+	assert.Nil(t, slice.Load(0))
+	old := slice.UpdateAt(0, "a")
+	assert.Nil(t, old)
+	old = slice.UpdateAt(1, nil)
+	assert.Equal(t, 1, old)
+	assert.Equal(t, "a", slice.Load(0))
+	assert.Nil(t, slice.Load(1))
+	assert.Equal(t, 2, slice.Length())
+}
+
+func TestLimitedSlice_UpdateAtAllocs(t *testing.T) {
+	slice := NewLimitedSlice(10)
+	for i := 0; i < 10; i++ {
+		slice.Append(i)
+	}
+
+	var values [2]interface{}
+	values[0] = &struct{ a, b int }{1, 2}
+	values[1] = "string"
+
+	var i int
+	allocs := testing.AllocsPerRun(1000, func() {
+		slice.UpdateAt(i%10, values[i%2])
+		i++
+	})
+	assert.Equal(t, float64(0), allocs)
+
+	allocs = testing.AllocsPerRun(1000, func() {
+		_ = slice.Load(i % 10)
+		i++
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func TestLimitedSliceSlot(t *testing.T) {
+	type large struct{ a, b, c, d int }
+	var nilPointer *int
+	values := []interface{}{nil, 1, "string", 3.14, &large{1, 2, 3, 4}, large{1, 2, 3, 4}, nilPointer, []int{1, 2}, error(nil)}
+
+	guard := limitedSliceDomain.Pin()
+	defer guard.Unpin()
+
+	var slot limitedSliceSlot
+	_, ok := slot.load()
+	assert.False(t, ok)
+	_, ok = slot.update(guard, 1)
+	assert.False(t, ok)
+	_, ok = slot.load()
+	assert.False(t, ok)
+
+	slot.store(values[0])
+	for i := 1; i < len(values); i++ {
+		old, ok := slot.update(guard, values[i])
+		assert.True(t, ok)
+		assert.Equal(t, values[i-1], old)
+		p, ok := slot.load()
+		assert.True(t, ok)
+		assert.Equal(t, values[i], p)
+	}
+	slot.reset(guard)
+	_, ok = slot.load()
+	assert.False(t, ok)
+}
+
+func TestLimitedSlice_UpdateAtUnwritten(t *testing.T) {
+	slice := NewLimitedSlice(2)
+	slice.Append(1)
+	assert.Panics(t, func() { slice.UpdateAt(1, 2) })
+	assert.Nil(t, slice.Load(1))
+
+	slice.Seal()
+	slice.Truncate(0)
+	assert.Panics(t, func() { slice.UpdateAt(0, 2) })
+	assert.Equal(t, 0, slice.Length())
+}
+
+func TestLimitedSlice_ConcurrentlyUpdateAtLoad(t *testing.T) {
+	// 读取过程不会读到写给另一个下标的值，也不会读到拼起来的值
+	const capacity = 8
+	slice := NewLimitedSlice(capacity)
+	for index := 0; index < capacity; index++ {
+		slice.Append(index)
+	}
+	rounds := 20000
+	if testing.Short() {
+		rounds = 2000
+	}
+
+	var done atomic.Bool
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for !done.Load() {
+				slice.Range(func(index int, p interface{}) (stopIteration bool) {
+					if value := p.(int); value%capacity != index {
+						t.Errorf("index %d, value %d", index, value)
+					}
+					return false
+				})
+			}
+		}()
+	}
+
+	var writers sync.WaitGroup
+	for index := 0; index < capacity; index++ {
+		writers.Add(1)
+		go func(index int) {
+			defer writers.Done()
+			for round := 1; round <= rounds; round++ {
+				old := slice.UpdateAt(index, round*capacity+index)
+				if old.(int)%capacity != index {
+					t.Errorf("index %d, old %d", index, old)
+				}
+			}
+		}(index)
+	}
+	writers.Wait()
+	done.Store(true)
+	readers.Wait()
+}
+
+func TestPaddedLimitedSlice(t *testing.T) {
+	assert.Equal(t, uintptr(cpu.CacheLineSize), unsafe.Sizeof(paddedLimitedSliceSlot{}))
+	assert.Equal(t, uintptr(cpu.CacheLineSize), unsafe.Sizeof(paddedAppendIndex{}))
+	// 紧凑布局不带填充
+	assert.LessOrEqual(t, int(unsafe.Sizeof(LimitedSlice{})), cpu.CacheLineSize)
+
+	slice := NewPaddedLimitedSlice(100)
+	assert.True(t, slice.Padded())
+	assert.False(t, NewLimitedSlice(100).Padded())
+
+	// 每个过程更新自己的相邻下标
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		index, ok := slice.Append(0)
+		assert.True(t, ok)
+
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+
+			for j := 1; j <= 1000; j++ {
+				slice.UpdateAt(index, j)
+			}
+		}(index)
+	}
+	wg.Wait()
+
+	_, ok := slice.Append(0)
+	assert.False(t, ok)
+	assert.Equal(t, 100, slice.Length())
+	slice.Range(func(index int, p interface{}) (stopIteration bool) {
+		assert.Equal(t, 1000, p)
+		return false
+	})
+}
+
+func TestLimitedSlice_Seal(t *testing.T) {
+	slice := NewLimitedSlice(100)
+
+	var wg sync.WaitGroup
+	var appended int64
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 10; j++ {
+				if _, ok := slice.Append(j); ok {
+					atomic.AddInt64(&appended, 1)
+				}
+			}
+		}()
+	}
+	slice.Seal()
+	// 封闭后，已经取得下标的元素都已写入
+	sealedLength := slice.Length()
+	wg.Wait()
+
+	assert.Equal(t, int64(sealedLength), appended)
+	assert.Equal(t, sealedLength, slice.Length())
+	_, ok := slice.Append(1)
+	assert.False(t, ok)
+
+	// 重复封闭
+	slice.Seal()
+	assert.Equal(t, sealedLength, slice.Length())
+}
+
+func TestLimitedSlice_Truncate(t *testing.T) {
+	slice := NewLimitedSlice(10)
+	for i := 0; i < 10; i++ {
+		slice.Append(i)
+	}
+	assert.Panics(t, func() { slice.Truncate(5) })
+
+	assert.Equal(t, 10, slice.Seal())
+	assert.Panics(t, func() { slice.Truncate(11) })
+	slice.Truncate(5)
+	assert.Equal(t, 5, slice.Length())
+	assert.Nil(t, slice.Load(7))
+
+	index, ok := slice.Append(5)
+	assert.True(t, ok)
+	assert.Equal(t, 5, index)
+}
+
+func TestLimitedSlice_ConcurrentlyLoadTruncateAppend(t *testing.T) {
+	// 截断后重新写入的槽，读取过程不会把新旧两个值的字拼在一起
+	slice := NewLimitedSlice(4)
+	const rounds = 20000
+
+	var done atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !done.Load() {
+				for index := 0; index < slice.Capacity(); index++ {
+					switch value := slice.Load(index).(type) {
+					case nil:
+					case int:
+						if value < 0 || value >= rounds {
+							t.Errorf("torn int: %d", value)
+							return
+						}
+					case string:
+						if len(value) > 8 || !strings.HasPrefix(value, "s") {
+							t.Errorf("torn string: %q", value)
+							return
+						}
+					default:
+						t.Errorf("unexpected type: %T", value)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	// 交替写入不同类型的值
+	for round := 0; round < rounds; round++ {
+		slice.Seal()
+		slice.Truncate(0)
+		for index := 0; index < slice.Capacity(); index++ {
+			if (round+index)%2 == 0 {
+				slice.Append(round)
+			} else {
+				slice.Append("s" + strconv.Itoa(round))
+			}
+		}
+	}
+	done.Store(true)
+	wg.Wait()
+}
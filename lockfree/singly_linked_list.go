@@ -1,101 +1,238 @@
 package lockfree
 
 import (
+	"sync"
 	"sync/atomic"
+	"unsafe"
+
+	"github.com/wencan/freesync/lockfree/epoch"
+	"github.com/wencan/freesync/lockfree/hazard"
 )
 
 // SinglyLinkedListNode 无锁单链表的节点。
 type SinglyLinkedListNode struct {
-	// value 数据元素。不会更新。
+	// value 数据元素。节点发布后不会更新。
 	value interface{}
 
-	next atomic.Value // *SinglyLinkedListNode
-
-	// placeholder 占位节点。
-	placeholder bool
+	next atomic.Pointer[SinglyLinkedListNode]
 }
 
 // SinglyLinkedList 无锁的单链表。
-// 限制：不能pop唯一的元素，链表内元素类型必须一致。
+// 最左边节点永远是占位节点，它的next才是第一个元素。
+// LeftPop后，被pop的节点成为新的占位节点，旧的占位节点被移除。
+// 注意：旧版本的LeftPop不能pop最后一个元素，链表中总会留下一个；现在可以pop出全部元素，链表为空时才返回false。
 type SinglyLinkedList struct {
-	// leftNode 链表的开始位置。最左边的节点。
-	// 最左边节点永远是占位节点。
-	leftNode *SinglyLinkedListNode
+	// leftNode 最左边的节点。永远是占位节点。
+	leftNode atomic.Pointer[SinglyLinkedListNode]
 
 	// rightNode 最右边的节点。并发场景下，未必是最右边的节点。但可以通过next追踪到最右边节点。
-	// 初始化时，是一个占位节点。后期随着添加元素，而更新。
-	rightNode atomic.Value
+	// 不会落后于leftNode。
+	rightNode atomic.Pointer[SinglyLinkedListNode]
+
+	// epochDomain 纪元回收域。
+	// epochDomain和hazardDomain最多设置一个。都为nil时，移除的节点交给GC。
+	epochDomain *epoch.Domain
+
+	// hazardDomain 风险指针回收域。
+	hazardDomain *hazard.Domain
+
+	// nodePool 回收的节点。
+	nodePool sync.Pool
+
+	// freeEpochNode、freeHazardNode 回收节点的函数。预先创建，避免每次回收都分配闭包。
+	freeEpochNode  func(obj interface{})
+	freeHazardNode func(p unsafe.Pointer)
 }
 
 // NewSinglyLinkedList 新建一个无锁的单链表。
 func NewSinglyLinkedList() *SinglyLinkedList {
 	slist := &SinglyLinkedList{}
-	slist.leftNode = &SinglyLinkedListNode{placeholder: true}
-	slist.rightNode.Store(&SinglyLinkedListNode{placeholder: true})
+	placeholder := &SinglyLinkedListNode{}
+	slist.leftNode.Store(placeholder)
+	slist.rightNode.Store(placeholder)
 
 	return slist
 }
 
+// NewSinglyLinkedListWithEpoch 新建一个无锁的单链表。
+// 移除的节点经纪元回收后，重用于之后添加的元素。
+func NewSinglyLinkedListWithEpoch(domain *epoch.Domain) *SinglyLinkedList {
+	slist := NewSinglyLinkedList()
+	slist.epochDomain = domain
+	slist.freeEpochNode = func(obj interface{}) {
+		slist.freeNode(obj.(*SinglyLinkedListNode))
+	}
+	return slist
+}
+
+// NewSinglyLinkedListWithHazard 新建一个无锁的单链表。
+// 移除的节点经风险指针回收后，重用于之后添加的元素。
+// 适合有过程可能长时间停顿的场景，停顿的过程不会阻止其它节点被回收。
+func NewSinglyLinkedListWithHazard(domain *hazard.Domain) *SinglyLinkedList {
+	slist := NewSinglyLinkedList()
+	slist.hazardDomain = domain
+	slist.freeHazardNode = func(p unsafe.Pointer) {
+		slist.freeNode((*SinglyLinkedListNode)(p))
+	}
+	return slist
+}
+
+// freeNode 把回收的节点放回节点池。
+func (slist *SinglyLinkedList) freeNode(node *SinglyLinkedListNode) {
+	node.value = nil
+	node.next.Store(nil)
+	slist.nodePool.Put(node)
+}
+
+// newNode 新建节点。启用回收时，优先重用回收的节点。
+func (slist *SinglyLinkedList) newNode(p interface{}) *SinglyLinkedListNode {
+	if slist.epochDomain != nil || slist.hazardDomain != nil {
+		if node, _ := slist.nodePool.Get().(*SinglyLinkedListNode); node != nil {
+			node.value = p
+			return node
+		}
+	}
+	return &SinglyLinkedListNode{value: p}
+}
+
+// nodeGuard 一次操作期间对节点的保护。根据链表的回收方式，使用纪元或者风险指针。
+type nodeGuard struct {
+	slist  *SinglyLinkedList
+	epoch  *epoch.Guard
+	hazard *hazard.Record
+}
+
+// enter 开始一次操作。操作结束时调用exit。
+func (slist *SinglyLinkedList) enter() nodeGuard {
+	guard := nodeGuard{slist: slist}
+	if slist.epochDomain != nil {
+		guard.epoch = slist.epochDomain.Pin()
+	} else if slist.hazardDomain != nil {
+		guard.hazard = slist.hazardDomain.Acquire()
+	}
+	return guard
+}
+
+// load 读取src指向的节点。操作结束前，或者同一个slot被再次load前，节点不会被回收。
+func (guard nodeGuard) load(slot int, src *atomic.Pointer[SinglyLinkedListNode]) *SinglyLinkedListNode {
+	if guard.hazard != nil {
+		return hazard.Protect(guard.hazard, slot, src)
+	}
+	return src.Load()
+}
+
+// retire 回收已经从链表移除的节点。
+func (guard nodeGuard) retire(node *SinglyLinkedListNode) {
+	if guard.epoch != nil {
+		guard.epoch.Retire(node, guard.slist.freeEpochNode)
+	} else if guard.hazard != nil {
+		guard.hazard.Retire(unsafe.Pointer(node), guard.slist.freeHazardNode)
+	}
+}
+
+// exit 结束操作。
+func (guard nodeGuard) exit() {
+	if guard.epoch != nil {
+		guard.epoch.Unpin()
+	} else if guard.hazard != nil {
+		guard.hazard.Release()
+	}
+}
+
 // LeftPop 返回并删除最左边的元素。
-// 如果slist为空，或者只有一个元素，返回nil。
-// 实现限制不能pop唯一的元素。暂时也不需要全部pop。
+// 最后一个元素也会被pop。如果slist为空，返回false。
 func (slist *SinglyLinkedList) LeftPop() (p interface{}, ok bool) {
-	// 最左边节点永远是占位节点。
-	// pop出最左边节点的next节点。
+	guard := slist.enter()
+	defer guard.exit()
+
 	for {
-		next, _ := slist.leftNode.next.Load().(*SinglyLinkedListNode)
+		leftNode := guard.load(0, &slist.leftNode)
+		rightNode := slist.rightNode.Load()
+		next := guard.load(1, &leftNode.next)
+		if leftNode != slist.leftNode.Load() {
+			// 其它过程pop了。重试
+			continue
+		}
 		if next == nil {
 			return nil, false
 		}
-		nextNext := next.next.Load()
-		if nextNext == nil {
-			// atomic.Value.CompareAndSwap不接受new值为nil
-			// 目前逻辑也无法安全移除最右边的节点
-			return nil, false
+		if leftNode == rightNode {
+			// rightNode落后了。先推进，保证rightNode不会指向被移除的节点
+			slist.rightNode.CompareAndSwap(rightNode, next)
+			continue
 		}
-		if slist.leftNode.next.CompareAndSwap(next, nextNext) {
-			return next.value, true
+
+		// 必须在CompareAndSwap之前读取。之后next可能被其它过程pop并回收
+		p = next.value
+		if slist.leftNode.CompareAndSwap(leftNode, next) {
+			guard.retire(leftNode)
+			return p, true
 		}
 		// 其它过程也在pop。重试
 	}
 }
 
-// RightPush 添加一个元素到最左边。
+// RightPush 添加一个元素到最右边。
 func (slist *SinglyLinkedList) RightPush(p interface{}) {
-	node := &SinglyLinkedListNode{}
-	node.value = p
+	guard := slist.enter()
+	defer guard.exit()
 
+	node := slist.newNode(p)
 	for {
-		rightNode := slist.followRightNode()
+		rightNode := guard.load(0, &slist.rightNode)
+		next := rightNode.next.Load()
+		if rightNode != slist.rightNode.Load() {
+			continue
+		}
+		if next != nil {
+			// rightNode落后了。帮助推进
+			slist.rightNode.CompareAndSwap(rightNode, next)
+			continue
+		}
 		if rightNode.next.CompareAndSwap(nil, node) {
-			slist.rightNode.Store(node)
+			slist.rightNode.CompareAndSwap(rightNode, node)
 			return
 		}
 	}
 }
 
-// followRightNode 最右边的节点。
-func (slist *SinglyLinkedList) followRightNode() *SinglyLinkedListNode {
-	rightNode := slist.rightNode.Load().(*SinglyLinkedListNode)
-	if rightNode.placeholder {
-		// 链表为空
-		return slist.leftNode
-	}
+// RightPeek 返回（不删除）最右边的元素。
+// 如果slist为空，返回nil。
+func (slist *SinglyLinkedList) RightPeek() interface{} {
+	guard := slist.enter()
+	defer guard.exit()
+
 	for {
-		nextNode, _ := rightNode.next.Load().(*SinglyLinkedListNode)
-		if nextNode != nil {
-			rightNode = nextNode
+		rightNode := guard.load(0, &slist.rightNode)
+		next := rightNode.next.Load()
+		if next != nil {
+			slist.rightNode.CompareAndSwap(rightNode, next)
 			continue
 		}
-		return rightNode
+		if rightNode == slist.leftNode.Load() {
+			// 只剩占位节点
+			return nil
+		}
+		return rightNode.value
 	}
 }
 
-// RightPeek 返回（不删除）最右边的元素。
-func (slist *SinglyLinkedList) RightPeek() interface{} {
-	right := slist.followRightNode()
-	if right == nil {
-		return nil
+// LeftPeek 返回（不删除）最左边的元素。
+// 如果slist为空，返回nil。
+func (slist *SinglyLinkedList) LeftPeek() interface{} {
+	guard := slist.enter()
+	defer guard.exit()
+
+	for {
+		leftNode := guard.load(0, &slist.leftNode)
+		next := guard.load(1, &leftNode.next)
+		if leftNode != slist.leftNode.Load() {
+			// 其它过程pop了。重试
+			continue
+		}
+		if next == nil {
+			return nil
+		}
+		return next.value
 	}
-	return right.value
 }
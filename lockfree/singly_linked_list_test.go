@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/wencan/freesync/lockfree/epoch"
+	"github.com/wencan/freesync/lockfree/hazard"
 )
 
 func TestSList(t *testing.T) {
@@ -33,8 +35,36 @@ func TestSList(t *testing.T) {
 		num = p.(int)
 		assert.Equal(t, 1, num)
 	}
+}
+
+func TestSList_LeftPopLast(t *testing.T) {
+	// 最后一个元素也可以pop
+	slist := NewSinglyLinkedList()
+	slist.RightPush(1)
+	slist.RightPush(2)
+
+	p, ok := slist.LeftPop()
+	assert.True(t, ok)
+	assert.Equal(t, 1, p)
+
+	p, ok = slist.LeftPop()
+	assert.True(t, ok)
+	assert.Equal(t, 2, p)
+
+	p, ok = slist.LeftPop()
+	assert.Nil(t, p)
+	assert.False(t, ok)
+	assert.Nil(t, slist.LeftPeek())
+	assert.Nil(t, slist.RightPeek())
 
-	// 实现限制，不能pop最后一个
+	// pop空之后还可以继续push
+	slist.RightPush(3)
+	assert.Equal(t, 3, slist.LeftPeek())
+	assert.Equal(t, 3, slist.RightPeek())
+	p, ok = slist.LeftPop()
+	assert.True(t, ok)
+	assert.Equal(t, 3, p)
+	assert.Nil(t, slist.LeftPeek())
 }
 
 func TestSList_ConcurrentlyRightPush(t *testing.T) {
@@ -76,18 +106,23 @@ func TestSList_ConcurrentlyRightPush(t *testing.T) {
 			missMapping[i] = 1
 		}
 	}
-	if len(missMapping) == 1 {
-		// 实现限制必须要留一个元素
-	} else {
-		for idx := range missMapping {
-			t.Errorf("not found %d", idx)
-		}
+	for idx := range missMapping {
+		t.Errorf("not found %d", idx)
 	}
 }
 
 func TestSList_ConcurrentlyPushAndPop(t *testing.T) {
+	testSListConcurrentlyPushAndPop(t, NewSinglyLinkedList(), 200*10000)
+}
+
+func TestSList_ConcurrentlyPushAndPopWithEpoch(t *testing.T) {
+	// 节点被回收重用
+	domain := epoch.NewDomain()
+	testSListConcurrentlyPushAndPop(t, NewSinglyLinkedListWithEpoch(domain), 20*10000)
+}
+
+func testSListConcurrentlyPushAndPop(t *testing.T, slist *SinglyLinkedList, big int) {
 	// 同时并发push和pop
-	big := 200 * 10000
 
 	rand.Seed(time.Now().UnixNano())
 	ch := make(chan int, big)
@@ -96,8 +131,6 @@ func TestSList_ConcurrentlyPushAndPop(t *testing.T) {
 	}
 	close(ch)
 
-	slist := NewSinglyLinkedList()
-
 	var wg sync.WaitGroup
 	var count uint64
 	var mapping sync.Map
@@ -141,11 +174,79 @@ func TestSList_ConcurrentlyPushAndPop(t *testing.T) {
 			missMapping[i] = 1
 		}
 	}
-	if len(missMapping) == 1 {
-		// 实现限制必须要留一个元素
-	} else {
-		for idx := range missMapping {
-			t.Errorf("not found %d", idx)
-		}
+	for idx := range missMapping {
+		t.Errorf("not found %d", idx)
+	}
+}
+
+func TestSList_RecycleNodes(t *testing.T) {
+	// 启用回收后，push和pop不再分配节点
+	slist := NewSinglyLinkedListWithEpoch(epoch.NewDomain())
+	for i := 0; i < 1000; i++ {
+		slist.RightPush(nil)
+		slist.LeftPop()
+	}
+	allocs := testing.AllocsPerRun(1000, func() {
+		slist.RightPush(nil)
+		slist.LeftPop()
+	})
+	assert.True(t, allocs < 0.5, "allocs: %v", allocs)
+}
+
+func TestSList_ConcurrentlyPushAndPopWithHazard(t *testing.T) {
+	domain := hazard.NewDomain()
+	testSListConcurrentlyPushAndPop(t, NewSinglyLinkedListWithHazard(domain), 20*10000)
+}
+
+func TestSList_HazardStalledReader(t *testing.T) {
+	// 一个读者停顿，占着最左边的节点。其它节点照常回收
+	domain := hazard.NewDomain()
+	slist := NewSinglyLinkedListWithHazard(domain)
+	slist.RightPush(0)
+
+	stalled := domain.Acquire()
+	protected := hazard.Protect(stalled, 0, &slist.leftNode)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 50000; j++ {
+				slist.RightPush(j)
+				slist.LeftPop()
+			}
+		}()
+	}
+	wg.Wait()
+	domain.Scan()
+
+	// 只有停顿读者登记的节点没有回收
+	assert.Equal(t, 1, domain.Pending())
+	assert.NotSame(t, protected, slist.leftNode.Load())
+
+	stalled.Release()
+	domain.Scan()
+	assert.Equal(t, 0, domain.Pending())
+}
+
+func TestSList_EpochStalledReader(t *testing.T) {
+	// 对比：纪元回收时，停顿的读者阻止所有节点被回收
+	domain := epoch.NewDomain()
+	slist := NewSinglyLinkedListWithEpoch(domain)
+
+	stalled := domain.Pin()
+	for j := 0; j < 1000; j++ {
+		slist.RightPush(j)
+		slist.LeftPop()
+	}
+	domain.Collect()
+	assert.Equal(t, 1000, domain.Pending())
+
+	stalled.Unpin()
+	for i := 0; i < 3; i++ {
+		domain.Collect()
 	}
+	assert.Equal(t, 0, domain.Pending())
 }
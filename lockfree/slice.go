@@ -1,69 +1,376 @@
 package lockfree
 
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+const (
+	// sliceChunkBaseBits 第一个二级目录大小的位数。之后每个二级目录的大小翻倍。
+	sliceChunkBaseBits = 3
+
+	// sliceDirectorySize 一级目录大小。
+	// 二级目录的大小依次为8、16、32...，一共能存放8*(2^48-1)个LimitedSlice，远超内存能容纳的元素数。
+	sliceDirectorySize = 48
+)
+
+// sliceChunk 二级目录。
+type sliceChunk []atomic.Pointer[LimitedSlice]
+
+// sliceDirectory 一级目录。
+type sliceDirectory [sliceDirectorySize]atomic.Pointer[sliceChunk]
+
+// sliceChunkPosition 第i个LimitedSlice所在的二级目录，和在二级目录中的位置。
+func sliceChunkPosition(i int) (chunk, offset int) {
+	chunk = bits.Len(uint(i>>sliceChunkBaseBits+1)) - 1
+	offset = i - (1<<chunk-1)<<sliceChunkBaseBits
+	return chunk, offset
+}
+
 // Slice 无锁slice实现。
-// 增加容量时，通过grow函数创建一个新的Slice对象。
+// 类似两级页表：一级目录在第一次增长时创建，二级目录和LimitedSlice都在增长时通过CAS安装。
+// 二级目录的大小依次翻倍，增长不复制目录，是O(1)的无锁操作。
+// 可以预先创建多个LimitedSlice，Append从appendCursor指向的LimitedSlice开始，写满后移到下一个。
 type Slice struct {
-	// limitedSlices 由多个LimitedSlice组成。
-	// Slice对象内的limitedSlices不会变。变的是LimitedSlice内部数据。
-	limitedSlices []*LimitedSlice
+	// directory 一级目录。还没有增长过时为nil。
+	// 第i个LimitedSlice的位置由sliceChunkPosition(i)决定，起始索引和容量只由i决定。
+	directory atomic.Pointer[sliceDirectory]
+
+	// limitedSlicesNum 已经安装的LimitedSlice数量。
+	// 最高位为1表示增长已封闭。先安装LimitedSlice，再增加数量。
+	limitedSlicesNum atomic.Uint64
+
+	// padded 新增的LimitedSlice是否每个元素独占一个缓存行。
+	padded bool
+
+	// appendCursor Append使用的LimitedSlice。之前的LimitedSlice都已写满或者已封闭。
+	// 低32位是LimitedSlice的位置，高32位是代数。
+	// Truncate重置位置时增加代数，避免Append根据过时的判断把位置移过重新开放的LimitedSlice。
+	appendCursor atomic.Uint64
+}
+
+// cursorIndex appendCursor的位置。
+func cursorIndex(cursor uint64) int {
+	return int(uint32(cursor))
+}
 
-	// limitSlicesNum limitedSlices数量。
-	// Slice对象内的limitedSlices数量不会发生变化。
-	limitSlicesNum int
+// resetAppendCursor 把appendCursor重置到index，并增加代数。
+func (s *Slice) resetAppendCursor(index int) {
+	generation := s.appendCursor.Load() >> 32
+	s.appendCursor.Store((generation+1)<<32 | uint64(index))
+}
 
-	// slicesStartIndex 每个LimitedSlice的起始索引。
-	slicesStartIndex []int
+// NewPaddedSlice 新建一个空的Slice，增长出的元素都独占一个缓存行。
+func NewPaddedSlice() *Slice {
+	return &Slice{padded: true}
+}
 
-	// capacity 总容量。
-	// Slice对象内的容量不会发生变化。
-	capacity int
+// NewSliceWithCapacity 新建一个至少有capacity容量的Slice。
+func NewSliceWithCapacity(capacity int) *Slice {
+	s := &Slice{}
+	s.GrowTo(capacity)
+	return s
 }
 
-// Grow 返回一个新的容量更大的Slice对象，和增加的容量。
-// 原Slice对象不变。返回的新Slice对象会拥有原Slice的数据和新增的空间。
+// limitedSliceCapacity 第i个LimitedSlice的容量。
+// 依次为8、16、32...512，之后都是1024。slicesPostion依赖这个规律。
+func limitedSliceCapacity(i int) int {
+	if i < 7 {
+		return 8 << i
+	}
+	return 1024
+}
+
+// limitedSliceStartIndex 第i个LimitedSlice的起始索引。也是前i个LimitedSlice的总容量。
+func limitedSliceStartIndex(i int) int {
+	if i < 7 {
+		return 8<<i - 8
+	}
+	return 8 + 16 + 32 + 64 + 128 + 256 + 512 + (i-7)*1024
+}
+
+// numLimitedSlices 已经安装的LimitedSlice数量。
+func (s *Slice) numLimitedSlices() int {
+	return int(s.limitedSlicesNum.Load() &^ sealedBit)
+}
+
+// limitedSlice 第i个LimitedSlice。没有安装时返回nil。
+func (s *Slice) limitedSlice(i int) *LimitedSlice {
+	directory := s.directory.Load()
+	if directory == nil {
+		return nil
+	}
+	chunkIndex, offset := sliceChunkPosition(i)
+	chunk := directory[chunkIndex].Load()
+	if chunk == nil {
+		return nil
+	}
+	return (*chunk)[offset].Load()
+}
+
+// install 把LimitedSlice安装到第i个位置。已经有LimitedSlice时，保留原来的。
+func (s *Slice) install(i int, limitedSlice *LimitedSlice) {
+	directory := s.directory.Load()
+	if directory == nil {
+		s.directory.CompareAndSwap(nil, new(sliceDirectory))
+		directory = s.directory.Load()
+	}
+	chunkIndex, offset := sliceChunkPosition(i)
+	if chunkIndex >= sliceDirectorySize {
+		panic("impossibility")
+	}
+	chunkPtr := &directory[chunkIndex]
+	chunk := chunkPtr.Load()
+	if chunk == nil {
+		newChunk := make(sliceChunk, 1<<(chunkIndex+sliceChunkBaseBits))
+		chunkPtr.CompareAndSwap(nil, &newChunk)
+		chunk = chunkPtr.Load()
+	}
+	(*chunk)[offset].CompareAndSwap(nil, limitedSlice)
+}
+
+// Grow 在末尾增加一个LimitedSlice，返回s和增加的容量。无锁，可以并发。
+// 并发增长时，可能由其它过程完成增长。增长已封闭时，增加的容量为0。
+// 旧版本的Grow返回增长后的新Slice对象，现在原地增长，返回的总是s。
 func (s *Slice) Grow() (*Slice, int) {
-	// 最后一个数组的容量
-	var lastCapacity int
-	if len(s.limitedSlices) > 0 {
-		lastCapacity = s.limitedSlices[len(s.limitedSlices)-1].Capacity()
-	}
-
-	// 新数组
-	var tailCapacity int
-	switch lastCapacity { // 这里，switch 比 if，更能清晰展现逻辑
-	case 0:
-		tailCapacity = 8
-	case 8:
-		tailCapacity = 16
-	case 16:
-		tailCapacity = 32
-	case 32:
-		tailCapacity = 64
-	case 64:
-		tailCapacity = 128
-	case 128:
-		tailCapacity = 256
-	case 256:
-		tailCapacity = 512
-	default:
-		tailCapacity = 1024
+	num := s.limitedSlicesNum.Load()
+	if num&sealedBit != 0 {
+		return s, 0
+	}
+	i := int(num)
+	capacity := limitedSliceCapacity(i)
+	if s.limitedSlice(i) == nil {
+		if s.padded {
+			s.install(i, NewPaddedLimitedSlice(capacity))
+		} else {
+			s.install(i, NewLimitedSlice(capacity))
+		}
+	}
+	// 失败表示其它过程完成了增长，或者增长被封闭了
+	s.limitedSlicesNum.CompareAndSwap(num, num+1)
+	if s.limitedSlicesNum.Load()&sealedBit != 0 {
+		return s, 0
+	}
+	return s, capacity
+}
+
+// GrowTo 增长到容量至少为capacity。一次创建需要的全部LimitedSlice。
+// 增长已封闭时，返回false。
+func (s *Slice) GrowTo(capacity int) bool {
+	for s.Capacity() < capacity {
+		if _, added := s.Grow(); added == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sealGrowth 封闭增长，返回LimitedSlice数量。之后Grow增加的容量都为0。
+// 调用者需要保证sealGrowth、Truncate、Pop之间没有并发。
+func (s *Slice) sealGrowth() int {
+	for {
+		num := s.limitedSlicesNum.Load()
+		if num&sealedBit != 0 || s.limitedSlicesNum.CompareAndSwap(num, num|sealedBit) {
+			return int(num &^ sealedBit)
+		}
+	}
+}
+
+// unsealGrowth 重新开放增长。
+func (s *Slice) unsealGrowth() {
+	s.limitedSlicesNum.Store(uint64(s.numLimitedSlices()))
+}
+
+// shrink 返回一个只保留前n个LimitedSlice的新Slice对象。
+// 调用者需要先封闭增长。原Slice对象不再增长，新旧Slice对象共享保留的LimitedSlice。
+// 需要复制保留部分的目录，开销与n成正比。
+func (s *Slice) shrink(n int) *Slice {
+	num := s.limitedSlicesNum.Load()
+	if num&sealedBit == 0 {
+		panic("shrink unsealed slice")
+	}
+	if n > int(num&^sealedBit) {
+		panic("shrink beyond length")
+	}
+	newSlice := &Slice{padded: s.padded}
+	for i := 0; i < n; i++ {
+		newSlice.install(i, s.limitedSlice(i))
 	}
+	newSlice.limitedSlicesNum.Store(uint64(n))
+	if cursor := cursorIndex(s.appendCursor.Load()); cursor < n {
+		newSlice.appendCursor.Store(uint64(cursor))
+	} else if n > 0 {
+		newSlice.appendCursor.Store(uint64(n - 1))
+	}
+	return newSlice
+}
+
+// Truncate 截断为length个元素，返回新的Slice对象。length大于长度时，不截断。
+// 截断位置之后的LimitedSlice会被封闭并释放，包括预留的容量。返回新的Slice对象时，原Slice对象的Append位置被移到末尾，
+// Truncate返回后，通过原Slice对象的Append和Grow都会失败。
+// 调用者需要保证Truncate、Pop之间没有并发。
+//
+// 与Append并发时，线性化点是封闭正在Append的LimitedSlice的时刻：
+// 之前取得下标的Append都已写入，算作截断前的元素；之后的Append失败，调用者需要取得新的Slice对象后重试。
+// 例外是在移动Append位置之前已经读到了它、要写入保留的最后一个LimitedSlice的Append，它可能在重新开放后成功，
+// 元素写入了新旧Slice对象共享的LimitedSlice，算作截断后追加到新Slice对象的元素。
+func (s *Slice) Truncate(length int) *Slice {
+	num := s.sealGrowth()
+	if num == 0 {
+		s.unsealGrowth()
+		return s
+	}
+	total, sealedFrom := s.seal(num)
+	if length >= total {
+		// 不截断，重新开放
+		for i := sealedFrom; i < num; i++ {
+			segment := s.limitedSlice(i)
+			segment.Truncate(segment.Seal())
+		}
+		s.resetAppendCursor(sealedFrom)
+		s.unsealGrowth()
+		return s
+	}
+	return s.truncateSealed(num, total, length)
+}
+
+// Pop 删除并返回最后一个元素，和新的Slice对象。如果为空，返回false。
+// 并发规则和线性化点同Truncate。
+func (s *Slice) Pop() (p interface{}, ok bool, newSlice *Slice) {
+	num := s.sealGrowth()
+	if num == 0 {
+		s.unsealGrowth()
+		return nil, false, s
+	}
+	length, _ := s.seal(num)
+	if length == 0 {
+		return nil, false, s.truncateSealed(num, length, length)
+	}
+	p = s.Load(length - 1)
+	return p, true, s.truncateSealed(num, length, length-1)
+}
+
+// seal 封闭可能还有Append在写入的LimitedSlice，返回总长度，和第一个封闭的LimitedSlice。
+// appendCursor之前的LimitedSlice都已写满，但最后一个可能还有Append在写入，也要封闭。
+// 调用者需要先封闭增长。
+func (s *Slice) seal(num int) (total, sealedFrom int) {
+	sealedFrom = cursorIndex(s.appendCursor.Load()) - 1
+	if sealedFrom < 0 {
+		sealedFrom = 0
+	}
+	total = limitedSliceStartIndex(sealedFrom)
+	for i := sealedFrom; i < num; i++ {
+		if length := s.limitedSlice(i).Seal(); length > 0 {
+			total = limitedSliceStartIndex(i) + length
+		}
+	}
+	return total, sealedFrom
+}
 
-	tailLimitedSlice := NewLimitedSlice(tailCapacity)
+// truncateSealed 可能Append的LimitedSlice和增长都已封闭，截断为length个元素，返回新的Slice对象。
+func (s *Slice) truncateSealed(num, total, length int) *Slice {
+	if length > total {
+		length = total
+	}
+
+	// 保留起始索引小于length的LimitedSlice。长度为0时，保留第一个
+	keep := 1
+	for keep < num && limitedSliceStartIndex(keep) < length {
+		keep++
+	}
+	if keep < num {
+		// 原Slice对象将被丢弃。先把Append位置移到末尾，重新开放保留的最后一个LimitedSlice后，
+		// 通过原Slice对象的Append不会再写入它
+		s.resetAppendCursor(num)
+	}
+	tail := s.limitedSlice(keep - 1)
+	// 新的最后一个LimitedSlice可能早已写满，没有封闭，但可能还有Append在写入
+	tail.Seal()
+	tail.Truncate(length - limitedSliceStartIndex(keep-1))
 
-	// 新slice
-	newSlice := &Slice{
-		limitedSlices:    append(s.limitedSlices, tailLimitedSlice),
-		limitSlicesNum:   len(s.limitedSlices) + 1,
-		slicesStartIndex: append(s.slicesStartIndex, s.capacity),
-		capacity:         s.capacity + tailCapacity,
+	if keep == num {
+		s.resetAppendCursor(keep - 1)
+		s.unsealGrowth()
+		return s
 	}
-	return newSlice, tailCapacity
+	return s.shrink(keep)
+}
+
+// Seal 封闭。之后通过s的Append都会失败，Grow增加的容量都为0，也不能再Truncate、Pop。返回封闭时的长度。
+// 等到已经取得下标的Append都写入完成后返回。
+func (s *Slice) Seal() (length int) {
+	num := s.sealGrowth()
+	for i := 0; i < num; i++ {
+		if n := s.limitedSlice(i).Seal(); n > 0 {
+			length = limitedSliceStartIndex(i) + n
+		}
+	}
+	return length
+}
+
+// CompactTail 释放末尾元素都满足deleted的LimitedSlice，返回新的Slice对象。有效元素的下标不变。
+// 没有可以释放的空间时，返回s和false。
+//
+// 先封闭增长和末尾可能释放的LimitedSlice，之后通过s的Append都会失败；然后调用sealed(startIndex)，
+// startIndex是可能释放的第一个下标，调用者可以在sealed中等待还可能用UpdateAt写入这部分空间的过程结束。
+// sealed返回后重新检查，期间被重新写入的LimitedSlice保留，并重新开放Append。
+// 返回新的Slice对象后，s不再增长。如果保留了全部LimitedSlice，compacted为false。
+// 调用者需要保证CompactTail、Truncate、Pop之间没有并发。
+func (s *Slice) CompactTail(deleted func(p interface{}) bool, sealed func(startIndex int)) (newSlice *Slice, compacted bool) {
+	s.sealGrowth()
+	segments := s.limitedSlices()
+
+	keep := len(segments)
+	for keep > 0 && limitedSliceAll(segments[keep-1], deleted) {
+		keep--
+	}
+	if keep == len(segments) {
+		s.unsealGrowth()
+		return s, false
+	}
+
+	originalKeep := keep
+	for _, segment := range segments[keep:] {
+		segment.Seal()
+	}
+	sealed(limitedSliceStartIndex(keep))
+
+	for i := len(segments) - 1; i >= keep; i-- {
+		if !limitedSliceAll(segments[i], deleted) {
+			keep = i + 1
+			break
+		}
+	}
+	// 保留的LimitedSlice重新开放Append
+	for _, segment := range segments[originalKeep:keep] {
+		segment.Truncate(segment.Seal())
+	}
+	return s.shrink(keep), keep < len(segments)
+}
+
+// limitedSliceAll LimitedSlice内的元素是否都满足f。
+func limitedSliceAll(segment *LimitedSlice, f func(p interface{}) bool) bool {
+	all := true
+	segment.Range(func(index int, p interface{}) (stopIteration bool) {
+		all = f(p)
+		return !all
+	})
+	return all
+}
+
+// limitedSlices 组成Slice的LimitedSlice。返回的是当前的快照。
+func (s *Slice) limitedSlices() []*LimitedSlice {
+	num := s.numLimitedSlices()
+	segments := make([]*LimitedSlice, num)
+	for i := range segments {
+		segments[i] = s.limitedSlice(i)
+	}
+	return segments
 }
 
 // Capacity 容量。
 func (s *Slice) Capacity() int {
-	return s.capacity
+	return limitedSliceStartIndex(s.numLimitedSlices())
 }
 
 // slicesPostion 根据下标，计算元素存储在数组切片中的位置。
@@ -104,40 +411,44 @@ func slicesPostion(index int) (int, int) {
 // 如果成功，返回下标。
 // 如果失败，表示该grow了。
 func (s *Slice) Append(p interface{}) (int, bool) {
-	if s.limitSlicesNum == 0 {
-		return 0, false
-	}
-	index2d, ok := s.limitedSlices[s.limitSlicesNum-1].Append(p)
-	if !ok {
-		return 0, false
+	for {
+		cursor := s.appendCursor.Load()
+		index1d := cursorIndex(cursor)
+		if index1d >= s.numLimitedSlices() {
+			return 0, false
+		}
+		index2d, ok := s.limitedSlice(index1d).Append(p)
+		if ok {
+			return limitedSliceStartIndex(index1d) + index2d, true
+		}
+		if index1d >= s.numLimitedSlices()-1 {
+			return 0, false
+		}
+		// 已满或者已封闭，移到下一个LimitedSlice
+		s.appendCursor.CompareAndSwap(cursor, cursor+1)
 	}
-
-	return s.slicesStartIndex[s.limitSlicesNum-1] + index2d, true
 }
 
 // Load 根据下标取回一个元素。
 func (s *Slice) Load(index int) interface{} {
 	index1d, index2d := slicesPostion(index)
-	return s.limitedSlices[index1d].Load(index2d)
+	return s.limitedSlice(index1d).Load(index2d)
 }
 
 // UpdateAt 更新下标位置上的元素，返回旧值。
 func (s *Slice) UpdateAt(index int, p interface{}) (old interface{}) {
 	index1d, index2d := slicesPostion(index)
-	return s.limitedSlices[index1d].UpdateAt(index2d, p)
+	return s.limitedSlice(index1d).UpdateAt(index2d, p)
 }
 
 // Range 遍历。
 func (s *Slice) Range(f func(index int, p interface{}) (stopIteration bool)) {
 	var stop bool
-	for index1d, limitedSlice := range s.limitedSlices {
-		if stop {
-			break
-		}
-
-		limitedSlice.Range(func(index2d int, p interface{}) (stopIteration bool) {
-			index := s.slicesStartIndex[index1d] + index2d
-			stop := f(index, p)
+	num := s.numLimitedSlices()
+	for index1d := 0; index1d < num && !stop; index1d++ {
+		startIndex := limitedSliceStartIndex(index1d)
+		s.limitedSlice(index1d).Range(func(index2d int, p interface{}) (stopIteration bool) {
+			stop = f(startIndex+index2d, p)
 			return stop
 		})
 	}
@@ -0,0 +1,209 @@
+package lockfree
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlice_GrowTo(t *testing.T) {
+	// 一次预留和逐步增长的布局相同，slicesPostion都适用
+	reserved := NewSliceWithCapacity(100000)
+	grown := &Slice{}
+	for grown.Capacity() < 100000 {
+		capacity := grown.Capacity()
+		s, added := grown.Grow()
+		assert.Same(t, grown, s)
+		assert.Equal(t, capacity+added, grown.Capacity())
+	}
+	assert.Equal(t, grown.Capacity(), reserved.Capacity())
+	assert.True(t, reserved.GrowTo(100))
+
+	limitedSlices := reserved.limitedSlices()
+	for index := 0; index < reserved.Capacity(); index++ {
+		index1d, index2d := slicesPostion(index)
+		if !assert.Equal(t, index, limitedSliceStartIndex(index1d)+index2d) ||
+			!assert.True(t, index2d < limitedSlices[index1d].Capacity()) {
+			t.FailNow()
+		}
+	}
+}
+
+func TestSliceChunkPosition(t *testing.T) {
+	// 位置连续、不重叠，二级目录的大小依次翻倍
+	var chunk, offset int
+	for i := 0; i < 1<<20; i++ {
+		c, o := sliceChunkPosition(i)
+		if i > 0 && !(c == chunk && o == offset+1) && !(c == chunk+1 && o == 0 && offset == 1<<(chunk+sliceChunkBaseBits)-1) {
+			t.Fatalf("position of %d: (%d, %d) after (%d, %d)", i, c, o, chunk, offset)
+		}
+		chunk, offset = c, o
+	}
+
+	// 第8*(2^48-1)-1个LimitedSlice落在最后一个二级目录中
+	c, _ := sliceChunkPosition(1<<(sliceDirectorySize+sliceChunkBaseBits) - 8 - 1)
+	assert.Equal(t, sliceDirectorySize-1, c)
+
+	// 还没有增长时不分配目录
+	var s Slice
+	assert.Nil(t, s.directory.Load())
+	_, added := s.Grow()
+	assert.Equal(t, 8, added)
+	assert.NotNil(t, s.directory.Load())
+}
+
+func TestSlice_RangeStop(t *testing.T) {
+	// 停止遍历后，不再进入之后的LimitedSlice
+	s := NewSliceWithCapacity(100)
+	for i := 0; i < 100; i++ {
+		_, ok := s.Append(i)
+		assert.True(t, ok)
+	}
+
+	var visited []int
+	s.Range(func(index int, p interface{}) (stopIteration bool) {
+		visited = append(visited, p.(int))
+		return index == 3
+	})
+	assert.Equal(t, []int{0, 1, 2, 3}, visited)
+}
+
+func TestSlice_ConcurrentlyGrow(t *testing.T) {
+	// 并发增长不会重复安装，也不会跳过位置
+	slice := &Slice{}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 300; j++ {
+				slice.Grow()
+			}
+		}()
+	}
+	wg.Wait()
+
+	num := slice.numLimitedSlices()
+	assert.True(t, num >= 300 && num <= 3000)
+	assert.Nil(t, slice.limitedSlice(num))
+	for i, limitedSlice := range slice.limitedSlices() {
+		if !assert.NotNil(t, limitedSlice) || !assert.Equal(t, limitedSliceCapacity(i), limitedSlice.Capacity()) {
+			t.FailNow()
+		}
+	}
+
+	// 封闭后不能增长
+	assert.Equal(t, num, slice.sealGrowth())
+	_, added := slice.Grow()
+	assert.Equal(t, 0, added)
+	slice.unsealGrowth()
+	_, added = slice.Grow()
+	assert.Equal(t, limitedSliceCapacity(num), added)
+	assert.Equal(t, num+1, slice.numLimitedSlices())
+}
+
+func TestSlice_AppendReserved(t *testing.T) {
+	slice := NewSliceWithCapacity(10000)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	indexes := make(map[int]bool)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j++ {
+				index, ok := slice.Append(j)
+				assert.True(t, ok)
+				mu.Lock()
+				indexes[index] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 下标连续
+	for index := 0; index < 10000; index++ {
+		assert.True(t, indexes[index])
+	}
+	_, ok := slice.Append(0)
+	if slice.Capacity() == 10000 {
+		assert.False(t, ok)
+	}
+}
+
+func TestSlice_TruncateOldAppend(t *testing.T) {
+	// 截断后保留的最后一个LimitedSlice重新开放，原Slice对象的Append位置正指向它
+	slice := NewSliceWithCapacity(1000)
+	for i := 0; i < 3; i++ {
+		slice.Append(i)
+	}
+	newSlice := slice.Truncate(2)
+	assert.NotSame(t, slice, newSlice)
+
+	_, ok := slice.Append(100)
+	assert.False(t, ok)
+	_, added := slice.Grow()
+	assert.Equal(t, 0, added)
+
+	index, ok := newSlice.Append(3)
+	assert.True(t, ok)
+	assert.Equal(t, 2, index)
+	assert.Equal(t, 3, newSlice.Load(2))
+}
+
+func TestSlice_Seal(t *testing.T) {
+	slice := NewSliceWithCapacity(100)
+	for i := 0; i < 30; i++ {
+		slice.Append(i)
+	}
+	assert.Equal(t, 30, slice.Seal())
+
+	_, ok := slice.Append(30)
+	assert.False(t, ok)
+	_, added := slice.Grow()
+	assert.Equal(t, 0, added)
+	assert.Equal(t, 30, slice.Length())
+}
+
+func TestSlice_CompactTail(t *testing.T) {
+	deleted := func(p interface{}) bool {
+		return p == nil
+	}
+
+	slice := NewSliceWithCapacity(1000)
+	for i := 0; i < 1000; i++ {
+		slice.Append(i)
+	}
+	for i := 100; i < 1000; i++ {
+		slice.UpdateAt(i, nil)
+	}
+	capacity := slice.Capacity()
+
+	// 末尾没有可以释放的LimitedSlice
+	same, compacted := slice.CompactTail(func(p interface{}) bool { return false }, func(startIndex int) {
+		t.Fatal("nothing to compact")
+	})
+	assert.Same(t, slice, same)
+	assert.False(t, compacted)
+
+	// 期间被重新写入的LimitedSlice保留
+	var sealedAt int
+	newSlice, compacted := slice.CompactTail(deleted, func(startIndex int) {
+		sealedAt = startIndex
+		slice.UpdateAt(200, 200)
+	})
+	assert.True(t, compacted)
+	assert.Equal(t, limitedSliceStartIndex(4), sealedAt)
+	assert.True(t, newSlice.Capacity() > 200)
+	assert.True(t, newSlice.Capacity() < capacity)
+	assert.Equal(t, 200, newSlice.Load(200))
+	assert.Equal(t, 99, newSlice.Load(99))
+
+	_, ok := slice.Append(0)
+	assert.False(t, ok)
+}
@@ -0,0 +1,159 @@
+package freesync
+
+import (
+	"sync/atomic"
+)
+
+// onceCall 一次初始化。
+type onceCall[V any] struct {
+	sharedCall[V]
+}
+
+// newOnceCall 新建一次还没执行的初始化。
+func newOnceCall[V any]() *onceCall[V] {
+	return &onceCall[V]{sharedCall: newSharedCall[V]()}
+}
+
+// run 执行fn，保存结果。forget在通知等待者之前调用，用于撤销不保留的结果。
+func (call *onceCall[V]) run(fn func() (V, error), retry bool, forget func()) {
+	call.sharedCall.run(fn, func() {
+		// panic和runtime.Goexit的结果总是不保留
+		if call.panicErr != nil || call.err == ErrGoexit || (retry && call.err != nil) {
+			forget()
+		}
+	})
+}
+
+// OnceValue 可以重置的一次性初始化。
+// 第一次Get执行初始化函数，并发的Get等待它的结果，之后的Get直接返回结果。Reset后，下一次Get重新初始化。
+// 初始化panic时，所有等待者都以*PanicError panic，下一次Get重新初始化。
+// 初始化函数调用runtime.Goexit时，等待者得到ErrGoexit，下一次Get重新初始化。
+type OnceValue[T any] struct {
+	fn func() (T, error)
+
+	// retry 初始化返回错误时，不保留结果，下一次Get重试。
+	retry bool
+
+	// call 当前的初始化。为nil表示还没初始化。
+	call atomic.Pointer[onceCall[T]]
+}
+
+// NewOnceValue 新建一个OnceValue。初始化返回的错误同值一样保留，直到Reset。
+func NewOnceValue[T any](fn func() (T, error)) *OnceValue[T] {
+	return &OnceValue[T]{fn: fn}
+}
+
+// NewOnceValueWithRetry 新建一个OnceValue。初始化返回错误时不保留，下一次Get重试。
+func NewOnceValueWithRetry[T any](fn func() (T, error)) *OnceValue[T] {
+	return &OnceValue[T]{fn: fn, retry: true}
+}
+
+// Get 返回初始化的结果。还没初始化时，执行初始化；正在初始化时，等待它结束。
+func (once *OnceValue[T]) Get() (T, error) {
+	for {
+		if call := once.call.Load(); call != nil {
+			return call.result()
+		}
+
+		call := newOnceCall[T]()
+		if !once.call.CompareAndSwap(nil, call) {
+			continue
+		}
+		call.run(once.fn, once.retry, func() {
+			once.call.CompareAndSwap(call, nil)
+		})
+		return call.result()
+	}
+}
+
+// Reset 丢弃结果，下一次Get重新初始化。
+// 正在初始化时，等待它结束后再丢弃，初始化之间不会重叠；它的等待者依然得到它的结果。
+// 不能在初始化函数中调用Reset，否则永远等待。
+func (once *OnceValue[T]) Reset() {
+	call := once.call.Load()
+	if call == nil {
+		return
+	}
+	<-call.done
+	// 等待期间已经换成了新的初始化时，它开始于Reset之后，不需要丢弃
+	once.call.CompareAndSwap(call, nil)
+}
+
+// OnceMap 按键的一次性初始化。
+// 每个键的第一次Get执行初始化函数，之后返回同一个结果。不同的键并行初始化，互不等待。
+// 初始化记录在无锁的Ctrie中，读取已经初始化的键不加锁。
+type OnceMap[K comparable, V any] struct {
+	fn func(key K) (V, error)
+
+	// retry 初始化返回错误时，不保留结果，下一次Get重试。
+	retry bool
+
+	calls *Ctrie[K, *onceCall[V]]
+}
+
+// NewOnceMap 新建一个OnceMap。初始化返回的错误同值一样保留，直到Delete。
+func NewOnceMap[K comparable, V any](fn func(key K) (V, error)) *OnceMap[K, V] {
+	return &OnceMap[K, V]{
+		fn:    fn,
+		calls: NewCtrie[K, *onceCall[V]](),
+	}
+}
+
+// NewOnceMapWithRetry 新建一个OnceMap。初始化返回错误时不保留，下一次Get重试。
+func NewOnceMapWithRetry[K comparable, V any](fn func(key K) (V, error)) *OnceMap[K, V] {
+	onceMap := NewOnceMap(fn)
+	onceMap.retry = true
+	return onceMap
+}
+
+// Get 返回键初始化的结果。还没初始化时，执行初始化；正在初始化时，等待它结束。
+// 初始化panic时，所有等待者都以*PanicError panic，下一次Get重新初始化。
+func (onceMap *OnceMap[K, V]) Get(key K) (V, error) {
+	if call, ok := onceMap.calls.Load(key); ok {
+		return call.result()
+	}
+
+	call := newOnceCall[V]()
+	if existing, loaded := onceMap.calls.LoadOrStore(key, call); loaded {
+		return existing.result()
+	}
+	call.run(func() (V, error) {
+		return onceMap.fn(key)
+	}, onceMap.retry, func() {
+		onceMap.calls.CompareAndDelete(key, call)
+	})
+	return call.result()
+}
+
+// Delete 丢弃键的结果，下一次Get重新初始化。
+// 键正在初始化时，等待它结束后再丢弃，同一个键的初始化之间不会重叠；它的等待者依然得到它的结果。
+// 不能在这个键的初始化函数中调用Delete，否则永远等待。
+func (onceMap *OnceMap[K, V]) Delete(key K) {
+	call, ok := onceMap.calls.Load(key)
+	if !ok {
+		return
+	}
+	<-call.done
+	// 等待期间已经换成了新的初始化时，它开始于Delete之后，不需要丢弃
+	onceMap.calls.CompareAndDelete(key, call)
+}
+
+// Range 遍历已经成功初始化的键。不等待正在进行的初始化。
+func (onceMap *OnceMap[K, V]) Range(f func(key K, value V) (stopIteration bool)) {
+	onceMap.calls.Range(func(key K, call *onceCall[V]) (stopIteration bool) {
+		select {
+		case <-call.done:
+		default:
+			return false
+		}
+		if call.panicErr != nil || call.err != nil {
+			return false
+		}
+		return f(key, call.value)
+	})
+}
+
+// Length 记录的键的数量，包括正在初始化的键。
+func (onceMap *OnceMap[K, V]) Length() int {
+	return onceMap.calls.Length()
+}
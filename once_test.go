@@ -0,0 +1,358 @@
+package freesync
+
+import (
+	"errors"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnceValue(t *testing.T) {
+	var calls atomic.Int64
+	once := NewOnceValue(func() (int, error) {
+		return int(calls.Add(1)), nil
+	})
+
+	value, err := once.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+	value, _ = once.Get()
+	assert.Equal(t, 1, value)
+
+	once.Reset()
+	value, _ = once.Get()
+	assert.Equal(t, 2, value)
+}
+
+func TestOnceValue_Concurrently(t *testing.T) {
+	var calls atomic.Int64
+	release := make(chan struct{})
+	once := NewOnceValue(func() (int, error) {
+		<-release
+		return int(calls.Add(1)), nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := once.Get()
+			assert.NoError(t, err)
+			assert.Equal(t, 1, value)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int64(1), calls.Load())
+
+	// 重置与读取并发，每次读到的都是某一次初始化的结果
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				once.Reset()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				value, err := once.Get()
+				assert.NoError(t, err)
+				assert.Greater(t, value, 0)
+				assert.LessOrEqual(t, int64(value), calls.Load())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestOnceValue_ResetDuringInit(t *testing.T) {
+	var calls, running atomic.Int64
+	release := make(chan struct{})
+	once := NewOnceValue(func() (int, error) {
+		if running.Add(1) > 1 {
+			t.Error("overlapping initialization")
+		}
+		defer running.Add(-1)
+		<-release
+		return int(calls.Add(1)), nil
+	})
+
+	got := make(chan int)
+	go func() {
+		value, _ := once.Get()
+		got <- value
+	}()
+	for running.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 初始化结束前，Reset不返回，之后的Get等待这次初始化
+	reset := make(chan struct{})
+	go func() {
+		once.Reset()
+		close(reset)
+	}()
+	go func() {
+		value, _ := once.Get()
+		got <- value
+	}()
+	select {
+	case <-reset:
+		t.Fatal("reset should wait for the initialization")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, 1, <-got)
+	assert.Equal(t, 1, <-got)
+	<-reset
+
+	// 丢弃了结果
+	value, err := once.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, value)
+	assert.Equal(t, int64(2), calls.Load())
+}
+
+func TestOnceValue_Error(t *testing.T) {
+	wantErr := errors.New("failed")
+	var calls atomic.Int64
+	fn := func() (int, error) {
+		if calls.Add(1) == 1 {
+			return 0, wantErr
+		}
+		return 1, nil
+	}
+
+	// 错误保留
+	once := NewOnceValue(fn)
+	_, err := once.Get()
+	assert.Equal(t, wantErr, err)
+	_, err = once.Get()
+	assert.Equal(t, wantErr, err)
+	assert.Equal(t, int64(1), calls.Load())
+
+	// 错误不保留
+	calls.Store(0)
+	once = NewOnceValueWithRetry(fn)
+	_, err = once.Get()
+	assert.Equal(t, wantErr, err)
+	value, err := once.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+	value, _ = once.Get()
+	assert.Equal(t, 1, value)
+	assert.Equal(t, int64(2), calls.Load())
+}
+
+func TestOnceValue_Panic(t *testing.T) {
+	var calls atomic.Int64
+	once := NewOnceValue(func() (int, error) {
+		if calls.Add(1) == 1 {
+			panic("boom")
+		}
+		return 1, nil
+	})
+
+	assert.PanicsWithValue(t, "boom", func() {
+		defer func() {
+			panic(recover().(*PanicError).Value)
+		}()
+		once.Get()
+	})
+
+	// panic的结果不保留
+	value, err := once.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+}
+
+func TestOnceValue_Goexit(t *testing.T) {
+	var calls atomic.Int64
+	release := make(chan struct{})
+	once := NewOnceValue(func() (int, error) {
+		if calls.Add(1) == 1 {
+			<-release
+			runtime.Goexit()
+		}
+		return 1, nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		once.Get()
+	}()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 等待者得到错误，不是零值
+	waited := make(chan error)
+	go func() {
+		_, err := once.Get()
+		waited <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	<-done
+	assert.Equal(t, ErrGoexit, <-waited)
+
+	// 不保留
+	value, err := once.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+	assert.Equal(t, int64(2), calls.Load())
+}
+
+func TestOnceMap(t *testing.T) {
+	var calls atomic.Int64
+	onceMap := NewOnceMap(func(key int) (string, error) {
+		calls.Add(1)
+		if key < 0 {
+			return "", errors.New("negative")
+		}
+		return strconv.Itoa(key), nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := 0; key < 100; key++ {
+				value, err := onceMap.Get(key)
+				assert.NoError(t, err)
+				assert.Equal(t, strconv.Itoa(key), value)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(100), calls.Load())
+	assert.Equal(t, 100, onceMap.Length())
+
+	// 错误保留
+	_, err := onceMap.Get(-1)
+	assert.Error(t, err)
+	_, err = onceMap.Get(-1)
+	assert.Error(t, err)
+	assert.Equal(t, int64(101), calls.Load())
+
+	// 只遍历成功的键
+	var ranged int
+	onceMap.Range(func(key int, value string) (stopIteration bool) {
+		assert.GreaterOrEqual(t, key, 0)
+		ranged++
+		return false
+	})
+	assert.Equal(t, 100, ranged)
+
+	onceMap.Delete(1)
+	value, _ := onceMap.Get(1)
+	assert.Equal(t, "1", value)
+	assert.Equal(t, int64(102), calls.Load())
+}
+
+func TestOnceMap_Parallel(t *testing.T) {
+	// 不同的键并行初始化
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	onceMap := NewOnceMapWithRetry(func(key string) (int, error) {
+		started <- struct{}{}
+		<-release
+		return len(key), nil
+	})
+
+	var wg sync.WaitGroup
+	for _, key := range []string{"a", "bb"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			value, err := onceMap.Get(key)
+			assert.NoError(t, err)
+			assert.Equal(t, len(key), value)
+		}(key)
+	}
+	<-started
+	<-started
+	close(release)
+	wg.Wait()
+}
+
+func TestOnceMap_DeleteDuringInit(t *testing.T) {
+	var calls, running atomic.Int64
+	release := make(chan struct{})
+	onceMap := NewOnceMap(func(key string) (int, error) {
+		if running.Add(1) > 1 {
+			t.Error("overlapping initialization")
+		}
+		defer running.Add(-1)
+		<-release
+		return int(calls.Add(1)), nil
+	})
+
+	got := make(chan int)
+	go func() {
+		value, _ := onceMap.Get("key")
+		got <- value
+	}()
+	for running.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 初始化结束前，Delete不返回，之后的Get等待这次初始化
+	deleted := make(chan struct{})
+	go func() {
+		onceMap.Delete("key")
+		close(deleted)
+	}()
+	go func() {
+		value, _ := onceMap.Get("key")
+		got <- value
+	}()
+	select {
+	case <-deleted:
+		t.Fatal("delete should wait for the initialization")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, 1, <-got)
+	assert.Equal(t, 1, <-got)
+	<-deleted
+
+	// 丢弃了结果
+	assert.Equal(t, 0, onceMap.Length())
+	value, err := onceMap.Get("key")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, value)
+
+	// 删除不存在的键
+	onceMap.Delete("missing")
+}
+
+func TestOnceMap_Retry(t *testing.T) {
+	var calls atomic.Int64
+	onceMap := NewOnceMapWithRetry(func(key string) (int, error) {
+		if calls.Add(1) == 1 {
+			return 0, errors.New("failed")
+		}
+		return 1, nil
+	})
+
+	_, err := onceMap.Get("key")
+	assert.Error(t, err)
+	assert.Equal(t, 0, onceMap.Length())
+	value, err := onceMap.Get("key")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+}
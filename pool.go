@@ -0,0 +1,345 @@
+package freesync
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/wencan/freesync/internal/cpu"
+	"github.com/wencan/freesync/lockfree/epoch"
+)
+
+// poolShardIdle 每个分片最多缓存的空闲对象数。
+const poolShardIdle = 8
+
+// PoolOptions 对象池的配置。
+type PoolOptions[T any] struct {
+	// MaxIdle 最多缓存的空闲对象数，包括分片缓存和全局栈。必须大于0。
+	// Evict后进入上一代的对象不计入，所以空闲对象最多为MaxIdle的两倍。
+	MaxIdle int
+
+	// New 池中没有空闲对象时，用于创建新对象。为nil时，Get返回零值。
+	New func() T
+
+	// Reset Put时重置对象。为nil时不重置。
+	Reset func(T)
+
+	// Shards 分片数。为0时等于GOMAXPROCS。
+	Shards int
+}
+
+// PoolStats 对象池的统计数据。
+type PoolStats struct {
+	// Hits Get拿到了空闲对象的次数。
+	Hits uint64
+
+	// Misses Get没有空闲对象，新建对象的次数。
+	Misses uint64
+
+	// Drops 空闲对象超出MaxIdle被丢弃，或者在上一代中没有被使用而被丢弃的次数。
+	Drops uint64
+
+	// Idle 当前的空闲对象数，包括上一代。
+	Idle int
+}
+
+// poolNode 空闲对象的节点。
+// 在栈中时，value、next、depth不会更新。出栈后经纪元回收才会重用。
+type poolNode[T any] struct {
+	value T
+	next  *poolNode[T]
+
+	// depth 节点在栈中的深度。栈顶节点的depth就是栈的大小。
+	depth int
+}
+
+// poolStack 有界的无锁栈。
+// 出栈的节点要经过纪元回收才会重用，访问栈的过程需要Pin住，避免ABA问题。
+type poolStack[T any] struct {
+	top atomic.Pointer[poolNode[T]]
+}
+
+// push 入栈。栈的大小达到limit时，返回false。
+func (stack *poolStack[T]) push(node *poolNode[T], limit int) bool {
+	for {
+		top := stack.top.Load()
+		depth := 1
+		if top != nil {
+			depth = top.depth + 1
+		}
+		if depth > limit {
+			return false
+		}
+		node.next = top
+		node.depth = depth
+		if stack.top.CompareAndSwap(top, node) {
+			return true
+		}
+	}
+}
+
+// pop 出栈。栈为空时，返回nil。调用者需要Pin住。
+func (stack *poolStack[T]) pop() *poolNode[T] {
+	for {
+		top := stack.top.Load()
+		if top == nil {
+			return nil
+		}
+		if stack.top.CompareAndSwap(top, top.next) {
+			return top
+		}
+	}
+}
+
+// size 栈的大小。调用者需要Pin住。
+func (stack *poolStack[T]) size() int {
+	if top := stack.top.Load(); top != nil {
+		return top.depth
+	}
+	return 0
+}
+
+// get 从分片缓存取出一个节点。没有时返回nil。
+func (shard *poolShard[T]) get(limit int) *poolNode[T] {
+	for i := 0; i < limit; i++ {
+		if shard.slots[i].Load() != nil {
+			if node := shard.slots[i].Swap(nil); node != nil {
+				return node
+			}
+		}
+	}
+	return nil
+}
+
+// put 把节点放入分片缓存。已满时返回false。
+func (shard *poolShard[T]) put(node *poolNode[T], limit int) bool {
+	for i := 0; i < limit; i++ {
+		if shard.slots[i].Load() == nil && shard.slots[i].CompareAndSwap(nil, node) {
+			return true
+		}
+	}
+	return false
+}
+
+// size 分片缓存的空闲对象数。
+func (shard *poolShard[T]) size() int {
+	var size int
+	for i := range shard.slots {
+		if shard.slots[i].Load() != nil {
+			size++
+		}
+	}
+	return size
+}
+
+// poolShard 分片。
+type poolShard[T any] struct {
+	// slots 分片缓存。Swap取出的节点归取出者独占，不存在ABA问题，不需要Pin住。
+	slots [poolShardIdle]atomic.Pointer[poolNode[T]]
+
+	// spare 一个空节点，供下次Put使用，避免访问节点池。
+	spare atomic.Pointer[poolNode[T]]
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	drops  atomic.Uint64
+
+	// 独占缓存行，避免相邻分片之间伪共享
+	_ [cpu.CacheLineSize]byte
+}
+
+// Pool 对象池。
+// 与sync.Pool不同，空闲对象的数量有上限，淘汰由Evict显式控制。
+// 空闲对象先放在当前处理器对应的分片缓存中，分片满了放进全局栈。都是无锁的。
+// 分片缓存命中时，不需要访问全局栈，也不需要Pin住。
+//
+// 空闲对象分两代：Evict把当前的空闲对象移入上一代，并丢弃上一代剩下的对象。
+// 定期调用Evict，连续两个周期没有被使用的对象会被释放。
+type Pool[T any] struct {
+	shards []poolShard[T]
+
+	// shardIdle 每个分片最多缓存的空闲对象数。
+	shardIdle int
+
+	// globalIdle 全局栈最多缓存的空闲对象数。
+	globalIdle int
+
+	// global 全局栈。
+	global poolStack[T]
+
+	// victim 上一代的空闲对象。
+	victim poolStack[T]
+
+	newFunc   func() T
+	resetFunc func(T)
+
+	// domain 访问栈期间Pin住，出栈的节点经纪元回收后重用。
+	domain *epoch.Domain
+
+	// nodes 回收的节点。
+	nodes sync.Pool
+
+	// freeNode 回收节点的函数。预先创建，避免每次回收都分配闭包。
+	freeNode func(obj interface{})
+
+	hint shardHint
+
+	// mu 保证Evict之间没有并发。
+	mu sync.Mutex
+}
+
+// NewPool 新建一个对象池。
+func NewPool[T any](options PoolOptions[T]) *Pool[T] {
+	if options.MaxIdle <= 0 {
+		panic("max idle must be positive")
+	}
+
+	shards := options.Shards
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	// 分片缓存最多占MaxIdle的一半，其余给全局栈
+	shardIdle := options.MaxIdle / 2 / shards
+	if shardIdle > poolShardIdle {
+		shardIdle = poolShardIdle
+	}
+
+	pool := &Pool[T]{
+		shards:     make([]poolShard[T], shards),
+		shardIdle:  shardIdle,
+		globalIdle: options.MaxIdle - shardIdle*shards,
+		newFunc:    options.New,
+		resetFunc:  options.Reset,
+		domain:     epoch.NewDomain(),
+	}
+	pool.freeNode = func(obj interface{}) {
+		node := obj.(*poolNode[T])
+		var zero T
+		node.value = zero
+		node.next = nil
+		pool.nodes.Put(node)
+	}
+	return pool
+}
+
+// Get 取出一个空闲对象。没有空闲对象时，用New新建。
+func (pool *Pool[T]) Get() T {
+	shard := &pool.shards[pool.hint.shard(len(pool.shards))]
+
+	if node := shard.get(pool.shardIdle); node != nil {
+		value := node.value
+		// 独占的节点，直接重用
+		var zero T
+		node.value = zero
+		if !shard.spare.CompareAndSwap(nil, node) {
+			pool.nodes.Put(node)
+		}
+		shard.hits.Add(1)
+		return value
+	}
+
+	guard := pool.domain.Pin()
+	node := pool.global.pop()
+	if node == nil {
+		node = pool.victim.pop()
+	}
+	if node != nil {
+		value := node.value
+		guard.Retire(node, pool.freeNode)
+		guard.Unpin()
+		shard.hits.Add(1)
+		return value
+	}
+	guard.Unpin()
+
+	shard.misses.Add(1)
+	if pool.newFunc != nil {
+		return pool.newFunc()
+	}
+	var zero T
+	return zero
+}
+
+// Put 放回一个对象。空闲对象已达到MaxIdle时，丢弃。
+func (pool *Pool[T]) Put(value T) {
+	if pool.resetFunc != nil {
+		pool.resetFunc(value)
+	}
+
+	shard := &pool.shards[pool.hint.shard(len(pool.shards))]
+
+	node := shard.spare.Swap(nil)
+	if node == nil {
+		node, _ = pool.nodes.Get().(*poolNode[T])
+	}
+	if node == nil {
+		node = &poolNode[T]{}
+	}
+	node.value = value
+
+	if shard.put(node, pool.shardIdle) {
+		return
+	}
+
+	guard := pool.domain.Pin()
+	ok := pool.global.push(node, pool.globalIdle)
+	guard.Unpin()
+	if !ok {
+		shard.drops.Add(1)
+		pool.freeNode(node)
+	}
+}
+
+// Evict 开始新的一代。
+// 丢弃上一代剩下的空闲对象，当前全部空闲对象（包括分片缓存）移入上一代。
+// 上一代的对象依然可以被Get取出。
+func (pool *Pool[T]) Evict() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	guard := pool.domain.Pin()
+	defer guard.Unpin()
+
+	// 整个全局栈成为上一代
+	dropped := pool.victim.top.Swap(pool.global.top.Swap(nil))
+	if dropped != nil {
+		pool.shards[0].drops.Add(uint64(dropped.depth))
+	}
+	for node := dropped; node != nil; {
+		next := node.next
+		guard.Retire(node, pool.freeNode)
+		node = next
+	}
+
+	// 分片缓存逐个移入上一代。上一代最多MaxIdle个，不会失败
+	for i := range pool.shards {
+		for {
+			node := pool.shards[i].get(pool.shardIdle)
+			if node == nil {
+				break
+			}
+			if !pool.victim.push(node, pool.globalIdle+pool.shardIdle*len(pool.shards)) {
+				pool.shards[i].drops.Add(1)
+				pool.freeNode(node)
+			}
+		}
+	}
+}
+
+// Stats 统计数据。
+func (pool *Pool[T]) Stats() PoolStats {
+	// 读取全局栈顶节点的深度，需要Pin住
+	guard := pool.domain.Pin()
+	defer guard.Unpin()
+
+	var stats PoolStats
+	for i := range pool.shards {
+		shard := &pool.shards[i]
+		stats.Hits += shard.hits.Load()
+		stats.Misses += shard.misses.Load()
+		stats.Drops += shard.drops.Load()
+		stats.Idle += shard.size()
+	}
+	stats.Idle += pool.global.size() + pool.victim.size()
+	return stats
+}
@@ -0,0 +1,46 @@
+package freesync
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func BenchmarkPool(b *testing.B) {
+	pool := NewPool(PoolOptions[*bytes.Buffer]{
+		MaxIdle: 1024,
+		New: func() *bytes.Buffer {
+			return new(bytes.Buffer)
+		},
+		Reset: func(buf *bytes.Buffer) {
+			buf.Reset()
+		},
+	})
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			buf := pool.Get()
+			buf.WriteByte('x')
+			pool.Put(buf)
+		}
+	})
+}
+
+func BenchmarkSyncPool(b *testing.B) {
+	pool := sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			buf := pool.Get().(*bytes.Buffer)
+			buf.WriteByte('x')
+			buf.Reset()
+			pool.Put(buf)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package freesync
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool_GetPut(t *testing.T) {
+	var created int
+	pool := NewPool(PoolOptions[*bytes.Buffer]{
+		MaxIdle: 4,
+		Shards:  1,
+		New: func() *bytes.Buffer {
+			created++
+			return new(bytes.Buffer)
+		},
+		Reset: func(buf *bytes.Buffer) {
+			buf.Reset()
+		},
+	})
+
+	buf := pool.Get()
+	assert.Equal(t, 1, created)
+	buf.WriteString("hello")
+	pool.Put(buf)
+
+	// 重用，并且已经重置
+	got := pool.Get()
+	assert.Same(t, buf, got)
+	assert.Equal(t, 0, got.Len())
+	assert.Equal(t, 1, created)
+
+	// 超出MaxIdle的被丢弃
+	for i := 0; i < 10; i++ {
+		pool.Put(new(bytes.Buffer))
+	}
+	stats := pool.Stats()
+	assert.Equal(t, 4, stats.Idle)
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(6), stats.Drops)
+
+	// 没有New时返回零值
+	empty := NewPool(PoolOptions[int]{MaxIdle: 1})
+	assert.Equal(t, 0, empty.Get())
+	empty.Put(5)
+	assert.Equal(t, 5, empty.Get())
+
+	assert.Panics(t, func() {
+		NewPool(PoolOptions[int]{})
+	})
+}
+
+func TestPool_Evict(t *testing.T) {
+	pool := NewPool(PoolOptions[int]{MaxIdle: 100, Shards: 2})
+	for i := 1; i <= 50; i++ {
+		pool.Put(i)
+	}
+	assert.Equal(t, 50, pool.Stats().Idle)
+
+	// 进入上一代，依然可以取出
+	pool.Evict()
+	assert.Equal(t, 50, pool.Stats().Idle)
+	assert.NotEqual(t, 0, pool.Get())
+
+	// 新放入的对象在当前代
+	pool.Put(100)
+	pool.Evict()
+	stats := pool.Stats()
+	assert.Equal(t, 1, stats.Idle)
+	assert.Equal(t, uint64(49), stats.Drops)
+	assert.Equal(t, 100, pool.Get())
+
+	pool.Evict()
+	pool.Evict()
+	assert.Equal(t, 0, pool.Stats().Idle)
+	assert.Equal(t, 0, pool.Get())
+}
+
+func TestPool_Concurrently(t *testing.T) {
+	// 同一个对象同时只会被一个过程持有
+	var created atomic.Int64
+	pool := NewPool(PoolOptions[*atomic.Bool]{
+		MaxIdle: 64,
+		New: func() *atomic.Bool {
+			created.Add(1)
+			return new(atomic.Bool)
+		},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 20000; j++ {
+				inUse := pool.Get()
+				if !inUse.CompareAndSwap(false, true) {
+					t.Error("object shared by two getters")
+					return
+				}
+				inUse.Store(false)
+				pool.Put(inUse)
+				if i == 0 && j%1000 == 0 {
+					pool.Evict()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats := pool.Stats()
+	assert.Equal(t, uint64(20*20000), stats.Hits+stats.Misses)
+	assert.Equal(t, uint64(created.Load()), stats.Misses)
+	assert.True(t, stats.Idle <= 2*64)
+}
+
+func TestPool_Allocs(t *testing.T) {
+	pool := NewPool(PoolOptions[*bytes.Buffer]{
+		MaxIdle: 16,
+		New: func() *bytes.Buffer {
+			return new(bytes.Buffer)
+		},
+	})
+	pool.Put(pool.Get())
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		pool.Put(pool.Get())
+	})
+	assert.True(t, allocs < 0.5, "allocs: %v", allocs)
+}
@@ -0,0 +1,320 @@
+package freesync
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// radixLeaf 叶子。保存完整的键和值。
+type radixLeaf[V any] struct {
+	key   string
+	value V
+}
+
+// radixEdge 指向子节点的边。
+type radixEdge[V any] struct {
+	// label 子节点前缀的首字节。
+	label byte
+
+	node *radixNode[V]
+}
+
+// radixNode 基数树的节点。不可变，修改时复制路径上的节点。
+type radixNode[V any] struct {
+	// prefix 从父节点到当前节点的边上的字符串。
+	prefix string
+
+	// leaf 以当前节点为终点的键。可能为nil。
+	leaf *radixLeaf[V]
+
+	// edges 按label排序。
+	edges []radixEdge[V]
+}
+
+// radixRoot 树的一个版本。
+type radixRoot[V any] struct {
+	node *radixNode[V]
+
+	// size 键的数量。
+	size int
+}
+
+// RadixTree 并发安全的基数树，用于前缀查找。
+// 读操作无锁，只需要一次原子加载；写操作复制修改路径上的节点，然后通过CAS替换根，冲突时重试。
+// 零值可用。
+type RadixTree[V any] struct {
+	root atomic.Pointer[radixRoot[V]]
+}
+
+// load 当前版本。
+func (tree *RadixTree[V]) load() *radixRoot[V] {
+	root := tree.root.Load()
+	if root == nil {
+		return &radixRoot[V]{node: &radixNode[V]{}}
+	}
+	return root
+}
+
+// Insert 插入或更新键。返回旧值，以及键是否已存在。
+func (tree *RadixTree[V]) Insert(key string, value V) (old V, updated bool) {
+	leaf := &radixLeaf[V]{key: key, value: value}
+	for {
+		expected := tree.root.Load()
+		root := tree.load()
+		node, old, updated := root.node.inserted(key, leaf)
+		size := root.size
+		if !updated {
+			size++
+		}
+		if tree.root.CompareAndSwap(expected, &radixRoot[V]{node: node, size: size}) {
+			return old, updated
+		}
+	}
+}
+
+// Delete 删除键。返回旧值，以及键是否存在。
+func (tree *RadixTree[V]) Delete(key string) (old V, deleted bool) {
+	for {
+		expected := tree.root.Load()
+		root := tree.load()
+		node, old, deleted := root.node.deleted(key, true)
+		if !deleted {
+			return old, false
+		}
+		if tree.root.CompareAndSwap(expected, &radixRoot[V]{node: node, size: root.size - 1}) {
+			return old, true
+		}
+	}
+}
+
+// Get 精确查找键。
+func (tree *RadixTree[V]) Get(key string) (value V, ok bool) {
+	node := tree.load().node
+	search := key
+	for {
+		if len(search) == 0 {
+			if node.leaf != nil {
+				return node.leaf.value, true
+			}
+			return value, false
+		}
+
+		_, child := node.edge(search[0])
+		if child == nil || !strings.HasPrefix(search, child.prefix) {
+			return value, false
+		}
+		node = child
+		search = search[len(child.prefix):]
+	}
+}
+
+// LongestPrefix 查找是key前缀的最长的键。
+func (tree *RadixTree[V]) LongestPrefix(key string) (prefix string, value V, ok bool) {
+	var last *radixLeaf[V]
+	node := tree.load().node
+	search := key
+	for {
+		if node.leaf != nil {
+			last = node.leaf
+		}
+		if len(search) == 0 {
+			break
+		}
+
+		_, child := node.edge(search[0])
+		if child == nil || !strings.HasPrefix(search, child.prefix) {
+			break
+		}
+		node = child
+		search = search[len(child.prefix):]
+	}
+
+	if last == nil {
+		return "", value, false
+	}
+	return last.key, last.value, true
+}
+
+// WalkPrefix 按键的顺序，遍历以prefix开头的键。遍历的是调用时的版本。
+func (tree *RadixTree[V]) WalkPrefix(prefix string, f func(key string, value V) (stopIteration bool)) {
+	node := tree.load().node
+	search := prefix
+	for len(search) > 0 {
+		_, child := node.edge(search[0])
+		if child == nil {
+			return
+		}
+		switch {
+		case strings.HasPrefix(search, child.prefix):
+			search = search[len(child.prefix):]
+		case strings.HasPrefix(child.prefix, search):
+			// prefix终止于边的中间
+			search = ""
+		default:
+			return
+		}
+		node = child
+	}
+	node.walk(f)
+}
+
+// Range 按键的顺序遍历。遍历的是调用时的版本。
+func (tree *RadixTree[V]) Range(f func(key string, value V) (stopIteration bool)) {
+	tree.load().node.walk(f)
+}
+
+// Length 长度。
+func (tree *RadixTree[V]) Length() int {
+	return tree.load().size
+}
+
+// edge 按label查找子节点。
+func (node *radixNode[V]) edge(label byte) (int, *radixNode[V]) {
+	index := sort.Search(len(node.edges), func(i int) bool {
+		return node.edges[i].label >= label
+	})
+	if index < len(node.edges) && node.edges[index].label == label {
+		return index, node.edges[index].node
+	}
+	return index, nil
+}
+
+// copy 浅复制节点。edges复制一份，可以修改。
+func (node *radixNode[V]) copy() *radixNode[V] {
+	return &radixNode[V]{
+		prefix: node.prefix,
+		leaf:   node.leaf,
+		edges:  append([]radixEdge[V](nil), node.edges...),
+	}
+}
+
+// withEdge 添加或替换一条边。只能用于新复制的节点。
+func (node *radixNode[V]) withEdge(child *radixNode[V]) {
+	label := child.prefix[0]
+	index, existing := node.edge(label)
+	if existing != nil {
+		node.edges[index].node = child
+		return
+	}
+	node.edges = append(node.edges, radixEdge[V]{})
+	copy(node.edges[index+1:], node.edges[index:])
+	node.edges[index] = radixEdge[V]{label: label, node: child}
+}
+
+// inserted 返回插入后的新节点。
+func (node *radixNode[V]) inserted(search string, leaf *radixLeaf[V]) (*radixNode[V], V, bool) {
+	var old V
+	if len(search) == 0 {
+		nc := node.copy()
+		nc.leaf = leaf
+		if node.leaf != nil {
+			return nc, node.leaf.value, true
+		}
+		return nc, old, false
+	}
+
+	_, child := node.edge(search[0])
+	if child == nil {
+		nc := node.copy()
+		nc.withEdge(&radixNode[V]{prefix: search, leaf: leaf})
+		return nc, old, false
+	}
+
+	common := commonPrefixLength(search, child.prefix)
+	if common == len(child.prefix) {
+		newChild, old, updated := child.inserted(search[common:], leaf)
+		nc := node.copy()
+		nc.withEdge(newChild)
+		return nc, old, updated
+	}
+
+	// 分裂子节点
+	split := &radixNode[V]{prefix: search[:common]}
+	movedChild := child.copy()
+	movedChild.prefix = child.prefix[common:]
+	split.withEdge(movedChild)
+	if common == len(search) {
+		split.leaf = leaf
+	} else {
+		split.withEdge(&radixNode[V]{prefix: search[common:], leaf: leaf})
+	}
+
+	nc := node.copy()
+	nc.withEdge(split)
+	return nc, old, false
+}
+
+// deleted 返回删除后的新节点。
+func (node *radixNode[V]) deleted(search string, isRoot bool) (*radixNode[V], V, bool) {
+	var old V
+	if len(search) == 0 {
+		if node.leaf == nil {
+			return node, old, false
+		}
+		nc := node.copy()
+		nc.leaf = nil
+		if !isRoot {
+			nc.merge()
+		}
+		return nc, node.leaf.value, true
+	}
+
+	index, child := node.edge(search[0])
+	if child == nil || !strings.HasPrefix(search, child.prefix) {
+		return node, old, false
+	}
+	newChild, old, deleted := child.deleted(search[len(child.prefix):], false)
+	if !deleted {
+		return node, old, false
+	}
+
+	nc := node.copy()
+	if newChild.leaf == nil && len(newChild.edges) == 0 {
+		nc.edges = append(nc.edges[:index], nc.edges[index+1:]...)
+		if !isRoot {
+			nc.merge()
+		}
+	} else {
+		nc.edges[index].node = newChild
+	}
+	return nc, old, true
+}
+
+// merge 没有叶子，只有一个子节点时，与子节点合并。只能用于新复制的节点。
+func (node *radixNode[V]) merge() {
+	if node.leaf != nil || len(node.edges) != 1 {
+		return
+	}
+	child := node.edges[0].node
+	node.prefix += child.prefix
+	node.leaf = child.leaf
+	node.edges = child.edges
+}
+
+// walk 先序遍历。
+func (node *radixNode[V]) walk(f func(key string, value V) (stopIteration bool)) (stop bool) {
+	if node.leaf != nil && f(node.leaf.key, node.leaf.value) {
+		return true
+	}
+	for _, edge := range node.edges {
+		if edge.node.walk(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// commonPrefixLength 公共前缀的长度。
+func commonPrefixLength(a, b string) int {
+	length := len(a)
+	if len(b) < length {
+		length = len(b)
+	}
+	for i := 0; i < length; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return length
+}
@@ -0,0 +1,145 @@
+package freesync
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRadixTree(t *testing.T) {
+	var tree RadixTree[int]
+
+	keys := []string{"", "a", "ab", "abc", "abd", "b", "ba", "foo", "foobar", "food"}
+	for i, key := range keys {
+		_, updated := tree.Insert(key, i)
+		assert.False(t, updated)
+	}
+	assert.Equal(t, len(keys), tree.Length())
+
+	old, updated := tree.Insert("foo", 100)
+	assert.True(t, updated)
+	assert.Equal(t, 7, old)
+
+	for i, key := range keys {
+		value, ok := tree.Get(key)
+		assert.True(t, ok, key)
+		if key != "foo" {
+			assert.Equal(t, i, value, key)
+		}
+	}
+	_, ok := tree.Get("fo")
+	assert.False(t, ok)
+	_, ok = tree.Get("abcd")
+	assert.False(t, ok)
+
+	// 最长前缀
+	prefix, value, ok := tree.LongestPrefix("foobaz")
+	assert.True(t, ok)
+	assert.Equal(t, "foo", prefix)
+	assert.Equal(t, 100, value)
+	prefix, _, ok = tree.LongestPrefix("abcx")
+	assert.True(t, ok)
+	assert.Equal(t, "abc", prefix)
+	prefix, _, ok = tree.LongestPrefix("zzz")
+	assert.True(t, ok)
+	assert.Equal(t, "", prefix)
+
+	// 前缀遍历，按键的顺序
+	walk := func(prefix string) []string {
+		var got []string
+		tree.WalkPrefix(prefix, func(key string, value int) (stopIteration bool) {
+			got = append(got, key)
+			return false
+		})
+		return got
+	}
+	assert.Equal(t, []string{"a", "ab", "abc", "abd"}, walk("a"))
+	assert.Equal(t, []string{"foo", "foobar", "food"}, walk("fo"))
+	assert.Equal(t, []string{"foobar"}, walk("foob"))
+	assert.Nil(t, walk("x"))
+	assert.Equal(t, keys, walk(""))
+
+	// 删除
+	old, deleted := tree.Delete("foo")
+	assert.True(t, deleted)
+	assert.Equal(t, 100, old)
+	_, deleted = tree.Delete("foo")
+	assert.False(t, deleted)
+	_, deleted = tree.Delete("fo")
+	assert.False(t, deleted)
+	assert.Equal(t, []string{"foobar", "food"}, walk("fo"))
+
+	for _, key := range keys {
+		tree.Delete(key)
+	}
+	assert.Equal(t, 0, tree.Length())
+	assert.Nil(t, walk(""))
+}
+
+func TestRadixTree_ConcurrentlyUpdate(t *testing.T) {
+	var tree RadixTree[int]
+	big := 2000
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for _, num := range rand.Perm(big) {
+				key := fmt.Sprintf("/api/%d/%d", num%7, num)
+				tree.Insert(key, num)
+				if num%2 == 1 {
+					tree.Delete(key)
+				}
+			}
+		}()
+	}
+
+	// 并发读，读到的值必须与键对应
+	done := make(chan struct{})
+	var wg2 sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg2.Add(1)
+		go func() {
+			defer wg2.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				tree.WalkPrefix("/api/3/", func(key string, value int) (stopIteration bool) {
+					assert.Equal(t, fmt.Sprintf("/api/3/%d", value), key)
+					return false
+				})
+				if prefix, value, ok := tree.LongestPrefix("/api/1/8/extra"); ok {
+					assert.Equal(t, "/api/1/8", prefix)
+					assert.Equal(t, 8, value)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	wg2.Wait()
+
+	assert.Equal(t, big/2, tree.Length())
+	var all []int
+	tree.Range(func(key string, value int) (stopIteration bool) {
+		all = append(all, value)
+		return false
+	})
+	sort.Ints(all)
+	want := make([]int, 0, big/2)
+	for i := 0; i < big; i += 2 {
+		want = append(want, i)
+	}
+	assert.Equal(t, want, all)
+}
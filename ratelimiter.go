@@ -0,0 +1,503 @@
+package freesync
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// ErrExceedsBurst 请求的令牌数超过了桶的容量，或者请求数超过了滑动窗口的限制，永远不能满足。
+var ErrExceedsBurst = errors.New("n exceeds burst")
+
+// Clock 时钟。测试时可以替换为可控的时钟。
+type Clock interface {
+	// Now 当前时间。
+	Now() time.Time
+
+	// Sleep 等待d，或者ctx结束。ctx结束时返回ctx.Err()。
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// systemClock 系统时钟。
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RateLimiterOptions 限流器的配置。
+type RateLimiterOptions struct {
+	// Rate 每秒产生的令牌数。必须大于0。
+	Rate float64
+
+	// Burst 桶的容量。必须大于0。装满整个桶的时间，即Burst/Rate秒，不能超过约146年。
+	Burst int
+
+	// Clock 时钟。为nil时使用系统时钟。
+	Clock Clock
+}
+
+// RateLimiter 无锁的令牌桶限流器。
+// 状态只有一个原子变量：桶重新装满的时刻（GCRA的理论到达时间）。
+// 取走令牌即把这个时刻推后，用一次CAS完成，不需要同时更新令牌数和时间。
+type RateLimiter struct {
+	// tat 桶重新装满的时刻，相对origin的纳秒数。不晚于当前时间时，桶是满的。
+	tat atomic.Int64
+
+	// interval 产生一个令牌的纳秒数。
+	interval int64
+
+	// capacity 装满整个桶的纳秒数。
+	capacity int64
+
+	// burst 桶的容量。
+	burst int64
+
+	clock  Clock
+	origin time.Time
+}
+
+// maxRateLimiterCapacity 装满整个桶的最大纳秒数，约146年。
+// 留出余量，当前时间加上桶的容量不会溢出。
+const maxRateLimiterCapacity = 1 << 62
+
+// NewRateLimiter 新建一个限流器。桶一开始是满的。
+// Rate太小或者Burst太大，装满整个桶超过maxRateLimiterCapacity时panic。
+func NewRateLimiter(options RateLimiterOptions) *RateLimiter {
+	interval, capacity := rateLimiterCapacity(options.Rate, options.Burst)
+	clock := options.Clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	return &RateLimiter{
+		interval: interval,
+		capacity: capacity,
+		burst:    int64(options.Burst),
+		clock:    clock,
+		origin:   clock.Now(),
+	}
+}
+
+// rateLimiterCapacity 检查配置，返回产生一个令牌和装满整个桶的纳秒数。
+func rateLimiterCapacity(rate float64, burst int) (interval, capacity int64) {
+	if !(rate > 0) {
+		panic("rate must be positive")
+	}
+	if burst <= 0 {
+		panic("burst must be positive")
+	}
+
+	// 先用浮点数比较，避免转换为整数时溢出
+	nanos := float64(time.Second) / rate
+	if nanos > maxRateLimiterCapacity {
+		panic("rate too small")
+	}
+	interval = int64(nanos)
+	if interval < 1 {
+		interval = 1
+	}
+	if int64(burst) > maxRateLimiterCapacity/interval {
+		panic("burst too large for the rate")
+	}
+	return interval, interval * int64(burst)
+}
+
+// now 当前时间，相对origin的纳秒数。
+func (limiter *RateLimiter) now() int64 {
+	return int64(limiter.clock.Now().Sub(limiter.origin))
+}
+
+// Allow 取走一个令牌。没有令牌时返回false。
+func (limiter *RateLimiter) Allow() bool {
+	return limiter.AllowN(1)
+}
+
+// AllowN 取走n个令牌。令牌不足时返回false，不取走任何令牌。
+func (limiter *RateLimiter) AllowN(n int) bool {
+	if n <= 0 {
+		return true
+	}
+	if int64(n) > limiter.burst {
+		// 先比较，n很大时cost会溢出
+		return false
+	}
+	cost := limiter.interval * int64(n)
+	now := limiter.now()
+	for {
+		tat := limiter.tat.Load()
+		newTat := maxInt64(tat, now) + cost
+		if newTat-now > limiter.capacity {
+			return false
+		}
+		if limiter.tat.CompareAndSwap(tat, newTat) {
+			return true
+		}
+	}
+}
+
+// Wait 等待并取走一个令牌。
+func (limiter *RateLimiter) Wait(ctx context.Context) error {
+	return limiter.WaitN(ctx, 1)
+}
+
+// WaitN 等待并取走n个令牌。n超过桶的容量时，返回ErrExceedsBurst。
+// 先预定令牌，再等待令牌产生。ctx结束时归还预定的令牌，返回ctx.Err()；
+// ctx的截止时间早于令牌产生的时间时，不预定，立即返回context.DeadlineExceeded。
+func (limiter *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	if int64(n) > limiter.burst {
+		// 先比较，n很大时cost会溢出
+		return ErrExceedsBurst
+	}
+	cost := limiter.interval * int64(n)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var delay, newTat int64
+	for {
+		now := limiter.now()
+		tat := limiter.tat.Load()
+		newTat = maxInt64(tat, now) + cost
+		delay = newTat - limiter.capacity - now
+		if deadline, ok := ctx.Deadline(); ok && delay > 0 && limiter.origin.Add(time.Duration(now+delay)).After(deadline) {
+			return context.DeadlineExceeded
+		}
+		if limiter.tat.CompareAndSwap(tat, newTat) {
+			break
+		}
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	if err := limiter.clock.Sleep(ctx, time.Duration(delay)); err != nil {
+		limiter.cancel(newTat, cost)
+		return err
+	}
+	return nil
+}
+
+// cancel 归还一次预定的令牌。newTat是预定后的tat。
+// 同x/time/rate：之后的预定已经按推后的时间等待，它们占用的部分不归还，否则新的请求会与它们同时得到令牌。
+// 已经到了取用时间的预定不归还。
+func (limiter *RateLimiter) cancel(newTat, cost int64) {
+	for {
+		if newTat-limiter.capacity <= limiter.now() {
+			return
+		}
+		tat := limiter.tat.Load()
+		refund := cost - (tat - newTat)
+		if refund <= 0 {
+			return
+		}
+		if limiter.tat.CompareAndSwap(tat, tat-refund) {
+			return
+		}
+	}
+}
+
+// Tokens 当前可用的令牌数。
+func (limiter *RateLimiter) Tokens() int {
+	now := limiter.now()
+	tokens := (now + limiter.capacity - maxInt64(limiter.tat.Load(), now)) / limiter.interval
+	return int(tokens)
+}
+
+// idle 桶已经满了多久。桶不满时返回0。
+func (limiter *RateLimiter) idle() time.Duration {
+	idle := limiter.now() - limiter.tat.Load()
+	if idle < 0 {
+		return 0
+	}
+	return time.Duration(idle)
+}
+
+// maxInt64 较大的一个。
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// KeyedLimiterOptions 按键限流的配置。
+type KeyedLimiterOptions struct {
+	// Rate 每个键每秒产生的令牌数。必须大于0。
+	Rate float64
+
+	// Burst 每个键的桶的容量。必须大于0。Burst/Rate秒不能超过约146年。
+	Burst int
+
+	// IdleTimeout 桶满了这么久的键会被淘汰，淘汰后再次使用时新建一个满的桶，效果相同。为0表示不淘汰。
+	IdleTimeout time.Duration
+
+	// Clock 时钟。为nil时使用系统时钟。
+	Clock Clock
+}
+
+// KeyedLimiter 按键限流。每个键一个令牌桶，登记在无锁的Ctrie中。
+// 访问时顺带清理：每隔IdleTimeout，由一个调用者淘汰空闲的键。
+// 淘汰与对同一个键的请求并发时，那次请求可能消耗被淘汰的桶，额外多得到一次额度。
+type KeyedLimiter[K comparable] struct {
+	limiters *Ctrie[K, *RateLimiter]
+
+	options RateLimiterOptions
+
+	idleTimeout time.Duration
+
+	// nextSweep 下一次清理的时间，UnixNano。
+	nextSweep atomic.Int64
+}
+
+// NewKeyedLimiter 新建一个按键的限流器。
+func NewKeyedLimiter[K comparable](options KeyedLimiterOptions) *KeyedLimiter[K] {
+	// 令牌桶在第一次使用键时才创建，这里先检查
+	rateLimiterCapacity(options.Rate, options.Burst)
+	if options.IdleTimeout < 0 {
+		panic("idle timeout must be non-negative")
+	}
+	clock := options.Clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	keyed := &KeyedLimiter[K]{
+		limiters: NewCtrie[K, *RateLimiter](),
+		options: RateLimiterOptions{
+			Rate:  options.Rate,
+			Burst: options.Burst,
+			Clock: clock,
+		},
+		idleTimeout: options.IdleTimeout,
+	}
+	keyed.nextSweep.Store(clock.Now().Add(options.IdleTimeout).UnixNano())
+	return keyed
+}
+
+// limiter 键对应的令牌桶，不存在时新建。
+func (keyed *KeyedLimiter[K]) limiter(key K) *RateLimiter {
+	if keyed.idleTimeout > 0 {
+		now := keyed.options.Clock.Now()
+		next := keyed.nextSweep.Load()
+		if now.UnixNano() >= next && keyed.nextSweep.CompareAndSwap(next, now.Add(keyed.idleTimeout).UnixNano()) {
+			keyed.Evict()
+		}
+	}
+
+	if limiter, ok := keyed.limiters.Load(key); ok {
+		return limiter
+	}
+	limiter, _ := keyed.limiters.LoadOrStore(key, NewRateLimiter(keyed.options))
+	return limiter
+}
+
+// Allow 为键取走一个令牌。没有令牌时返回false。
+func (keyed *KeyedLimiter[K]) Allow(key K) bool {
+	return keyed.limiter(key).Allow()
+}
+
+// AllowN 为键取走n个令牌。令牌不足时返回false。
+func (keyed *KeyedLimiter[K]) AllowN(key K, n int) bool {
+	return keyed.limiter(key).AllowN(n)
+}
+
+// Wait 为键等待并取走一个令牌。
+func (keyed *KeyedLimiter[K]) Wait(ctx context.Context, key K) error {
+	return keyed.limiter(key).Wait(ctx)
+}
+
+// WaitN 为键等待并取走n个令牌。
+func (keyed *KeyedLimiter[K]) WaitN(ctx context.Context, key K, n int) error {
+	return keyed.limiter(key).WaitN(ctx, n)
+}
+
+// Evict 立即淘汰桶满了IdleTimeout的键，返回淘汰的数量。
+func (keyed *KeyedLimiter[K]) Evict() int {
+	if keyed.idleTimeout <= 0 {
+		return 0
+	}
+	var evicted int
+	keyed.limiters.Range(func(key K, limiter *RateLimiter) (stopIteration bool) {
+		if limiter.idle() >= keyed.idleTimeout && keyed.limiters.CompareAndDelete(key, limiter) {
+			evicted++
+		}
+		return false
+	})
+	return evicted
+}
+
+// Length 登记的键的数量。
+func (keyed *KeyedLimiter[K]) Length() int {
+	return keyed.limiters.Length()
+}
+
+// maxSlidingWindowLimit 滑动窗口限流器的最大Limit。每个窗口的计数占16位。
+const maxSlidingWindowLimit = 1<<16 - 1
+
+// SlidingWindowLimiterOptions 滑动窗口限流器的配置。
+type SlidingWindowLimiterOptions struct {
+	// Limit 任意长度为Window的时间段内最多放行的请求数。必须在1到65535之间。
+	Limit int
+
+	// Window 窗口的长度。必须大于0。
+	Window time.Duration
+
+	// Clock 时钟。为nil时使用系统时钟。
+	Clock Clock
+}
+
+// SlidingWindowLimiter 无锁的滑动窗口限流器。
+// 按固定窗口计数，滑动窗口内的请求数按上一个窗口的计数与滑动窗口重叠的比例估算，加上当前窗口的计数。
+// 状态只有一个原子变量：当前窗口的序号、上一个窗口和当前窗口的计数，用一次CAS更新。
+// 同令牌桶相比，没有突发：窗口刚开始时，上一个窗口的请求几乎全部计入。
+type SlidingWindowLimiter struct {
+	// state 高32位是当前窗口的序号，中间16位是上一个窗口的计数，低16位是当前窗口的计数。
+	// 序号回绕后，空闲了2^32个窗口的限流器可能多计入一个窗口的请求。
+	state atomic.Uint64
+
+	// limit 滑动窗口内最多放行的请求数。
+	limit int64
+
+	// window 窗口的纳秒数。
+	window int64
+
+	clock  Clock
+	origin time.Time
+}
+
+// NewSlidingWindowLimiter 新建一个滑动窗口限流器。
+func NewSlidingWindowLimiter(options SlidingWindowLimiterOptions) *SlidingWindowLimiter {
+	if options.Limit <= 0 || options.Limit > maxSlidingWindowLimit {
+		panic("limit out of range")
+	}
+	if options.Window <= 0 {
+		panic("window must be positive")
+	}
+	clock := options.Clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	return &SlidingWindowLimiter{
+		limit:  int64(options.Limit),
+		window: int64(options.Window),
+		clock:  clock,
+		origin: clock.Now(),
+	}
+}
+
+// packSlidingWindow 组成状态。
+func packSlidingWindow(index uint32, prev, curr int64) uint64 {
+	return uint64(index)<<32 | uint64(prev)<<16 | uint64(curr)
+}
+
+// unpackSlidingWindow 按窗口index解读状态，返回上一个窗口和当前窗口的计数。
+// 状态记录的窗口比index新时，以状态的窗口为准，elapsed为0。
+func unpackSlidingWindow(state uint64, index uint32, elapsed int64) (newIndex uint32, newElapsed, prev, curr int64) {
+	stateIndex := uint32(state >> 32)
+	switch diff := int32(index - stateIndex); {
+	case diff < 0:
+		// 其它过程取得了更晚的时间
+		return stateIndex, 0, int64(state >> 16 & 0xffff), int64(state & 0xffff)
+	case diff == 0:
+		return index, elapsed, int64(state >> 16 & 0xffff), int64(state & 0xffff)
+	case diff == 1:
+		return index, elapsed, int64(state & 0xffff), 0
+	default:
+		return index, elapsed, 0, 0
+	}
+}
+
+// estimate 滑动窗口内的请求数。
+func (limiter *SlidingWindowLimiter) estimate(prev, curr, elapsed int64) float64 {
+	return float64(prev)*float64(limiter.window-elapsed)/float64(limiter.window) + float64(curr)
+}
+
+// take 放行n个请求。不能放行时，返回预计需要等待的纳秒数。
+func (limiter *SlidingWindowLimiter) take(n int) (ok bool, delay int64) {
+	cost := int64(n)
+	now := int64(limiter.clock.Now().Sub(limiter.origin))
+	for {
+		state := limiter.state.Load()
+		index, elapsed, prev, curr := unpackSlidingWindow(state, uint32(now/limiter.window), now%limiter.window)
+		if curr+cost > limiter.limit {
+			// 当前窗口已满，等到下一个窗口
+			return false, limiter.window - elapsed
+		}
+		if limiter.estimate(prev, curr+cost, elapsed) > float64(limiter.limit) {
+			// 等到上一个窗口的计数随重叠减少而降到足够低
+			allowed := float64(limiter.limit-curr-cost) * float64(limiter.window) / float64(prev)
+			delay := int64(math.Ceil(float64(limiter.window)-allowed)) - elapsed
+			return false, maxInt64(delay, 1)
+		}
+		if limiter.state.CompareAndSwap(state, packSlidingWindow(index, prev, curr+cost)) {
+			return true, 0
+		}
+	}
+}
+
+// Allow 放行一个请求。超过限制时返回false。
+func (limiter *SlidingWindowLimiter) Allow() bool {
+	return limiter.AllowN(1)
+}
+
+// AllowN 放行n个请求。超过限制时返回false，不计入任何请求。
+func (limiter *SlidingWindowLimiter) AllowN(n int) bool {
+	if n <= 0 {
+		return true
+	}
+	if int64(n) > limiter.limit {
+		return false
+	}
+	ok, _ := limiter.take(n)
+	return ok
+}
+
+// Wait 等待并放行一个请求。
+func (limiter *SlidingWindowLimiter) Wait(ctx context.Context) error {
+	return limiter.WaitN(ctx, 1)
+}
+
+// WaitN 等待并放行n个请求。n超过Limit时，返回ErrExceedsBurst。
+// 不预定：等到估算的时间后重试，并发的请求可能先被放行。
+// ctx结束时返回ctx.Err()；ctx的截止时间早于估算的时间时，立即返回context.DeadlineExceeded。
+func (limiter *SlidingWindowLimiter) WaitN(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	if int64(n) > limiter.limit {
+		return ErrExceedsBurst
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ok, delay := limiter.take(n)
+		if ok {
+			return nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && limiter.clock.Now().Add(time.Duration(delay)).After(deadline) {
+			return context.DeadlineExceeded
+		}
+		if err := limiter.clock.Sleep(ctx, time.Duration(delay)); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package freesync
+
+import (
+	"strconv"
+	"testing"
+)
+
+func BenchmarkRateLimiterAllow(b *testing.B) {
+	limiter := NewRateLimiter(RateLimiterOptions{Rate: 1e6, Burst: 1000})
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			limiter.Allow()
+		}
+	})
+}
+
+func BenchmarkKeyedLimiterAllow(b *testing.B) {
+	keyed := NewKeyedLimiter[string](KeyedLimiterOptions{Rate: 1e6, Burst: 1000})
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		var i int
+		for p.Next() {
+			keyed.Allow(keys[i%len(keys)])
+			i++
+		}
+	})
+}
@@ -0,0 +1,395 @@
+package freesync
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock 手动推进的时钟。
+type fakeClock struct {
+	mu       sync.Mutex
+	now      time.Time
+	sleepers []fakeSleeper
+}
+
+type fakeSleeper struct {
+	until time.Time
+	wake  chan struct{}
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.now
+}
+
+func (clock *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	clock.mu.Lock()
+	sleeper := fakeSleeper{until: clock.now.Add(d), wake: make(chan struct{})}
+	clock.sleepers = append(clock.sleepers, sleeper)
+	clock.mu.Unlock()
+
+	select {
+	case <-sleeper.wake:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Advance 推进时间，唤醒到期的Sleep。
+func (clock *fakeClock) Advance(d time.Duration) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	clock.now = clock.now.Add(d)
+	sleepers := clock.sleepers[:0]
+	for _, sleeper := range clock.sleepers {
+		if clock.now.Before(sleeper.until) {
+			sleepers = append(sleepers, sleeper)
+		} else {
+			close(sleeper.wake)
+		}
+	}
+	clock.sleepers = sleepers
+}
+
+// Sleepers 正在Sleep的数量。
+func (clock *fakeClock) Sleepers() int {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return len(clock.sleepers)
+}
+
+func TestRateLimiter_Allow(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewRateLimiter(RateLimiterOptions{Rate: 10, Burst: 3, Clock: clock})
+
+	assert.Equal(t, 3, limiter.Tokens())
+	assert.True(t, limiter.Allow())
+	assert.True(t, limiter.Allow())
+	assert.True(t, limiter.Allow())
+	assert.False(t, limiter.Allow())
+	assert.Equal(t, 0, limiter.Tokens())
+
+	clock.Advance(100 * time.Millisecond)
+	assert.Equal(t, 1, limiter.Tokens())
+	assert.False(t, limiter.AllowN(2))
+	assert.True(t, limiter.Allow())
+
+	// 最多装满
+	clock.Advance(time.Hour)
+	assert.Equal(t, 3, limiter.Tokens())
+	assert.False(t, limiter.AllowN(4))
+	assert.True(t, limiter.AllowN(3))
+	assert.True(t, limiter.AllowN(0))
+}
+
+func TestRateLimiter_Wait(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewRateLimiter(RateLimiterOptions{Rate: 10, Burst: 2, Clock: clock})
+
+	assert.NoError(t, limiter.Wait(context.Background()))
+	assert.NoError(t, limiter.Wait(context.Background()))
+	assert.Equal(t, ErrExceedsBurst, limiter.WaitN(context.Background(), 3))
+
+	done := make(chan error)
+	go func() {
+		done <- limiter.Wait(context.Background())
+	}()
+	for clock.Sleepers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// 令牌已被预定
+	assert.False(t, limiter.Allow())
+
+	clock.Advance(50 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("should wait for the token")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(50 * time.Millisecond)
+	assert.NoError(t, <-done)
+	assert.Equal(t, 0, limiter.Tokens())
+}
+
+func TestRateLimiter_WaitCancel(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewRateLimiter(RateLimiterOptions{Rate: 10, Burst: 1, Clock: clock})
+	assert.True(t, limiter.Allow())
+
+	// 取消后归还预定的令牌
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- limiter.Wait(ctx)
+	}()
+	for clock.Sleepers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	clock.Advance(100 * time.Millisecond)
+	assert.True(t, limiter.Allow())
+
+	// 截止时间之前等不到令牌，不预定
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, limiter.Wait(ctx))
+	clock.Advance(100 * time.Millisecond)
+	assert.Equal(t, 1, limiter.Tokens())
+}
+
+func TestRateLimiter_WaitCancelAfterLaterReservation(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewRateLimiter(RateLimiterOptions{Rate: 10, Burst: 1, Clock: clock})
+	assert.True(t, limiter.Allow())
+
+	// 先后两个预定，分别在100ms和200ms取用
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		first <- limiter.Wait(ctx)
+	}()
+	for clock.Sleepers() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan error)
+	go func() {
+		second <- limiter.Wait(context.Background())
+	}()
+	for clock.Sleepers() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 取消第一个。它的令牌已经被第二个预定推后占用，不能归还
+	cancel()
+	assert.Equal(t, context.Canceled, <-first)
+
+	clock.Advance(200 * time.Millisecond)
+	assert.NoError(t, <-second)
+	assert.False(t, limiter.Allow())
+
+	clock.Advance(100 * time.Millisecond)
+	assert.True(t, limiter.Allow())
+}
+
+func TestRateLimiter_Overflow(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewRateLimiter(RateLimiterOptions{Rate: 10, Burst: 3, Clock: clock})
+
+	// n很大时，interval*n溢出为负数，不能因此放行
+	huge := int(math.MaxInt64/limiter.interval) + 2
+	assert.Less(t, limiter.interval*int64(huge), int64(0))
+	assert.False(t, limiter.AllowN(huge))
+	assert.False(t, limiter.AllowN(math.MaxInt))
+	assert.Equal(t, ErrExceedsBurst, limiter.WaitN(context.Background(), huge))
+	assert.Equal(t, 3, limiter.Tokens())
+	assert.True(t, limiter.AllowN(3))
+	assert.False(t, limiter.Allow())
+
+	// 配置超出范围
+	assert.Panics(t, func() {
+		NewRateLimiter(RateLimiterOptions{Rate: 1e-12, Burst: 1})
+	})
+	assert.Panics(t, func() {
+		NewRateLimiter(RateLimiterOptions{Rate: 1, Burst: math.MaxInt})
+	})
+	assert.Panics(t, func() {
+		NewRateLimiter(RateLimiterOptions{Rate: math.NaN(), Burst: 1})
+	})
+	assert.Panics(t, func() {
+		NewKeyedLimiter[string](KeyedLimiterOptions{Rate: 1e-12, Burst: 1})
+	})
+
+	// 边界内
+	limiter = NewRateLimiter(RateLimiterOptions{Rate: math.Inf(1), Burst: math.MaxInt32, Clock: clock})
+	assert.True(t, limiter.AllowN(math.MaxInt32))
+	limiter = NewRateLimiter(RateLimiterOptions{Rate: 1e-9, Burst: 1, Clock: clock})
+	assert.True(t, limiter.Allow())
+	assert.False(t, limiter.Allow())
+}
+
+func TestRateLimiter_Concurrently(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewRateLimiter(RateLimiterOptions{Rate: 1, Burst: 100, Clock: clock})
+
+	// 时间不动时，恰好放行Burst个
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if limiter.Allow() {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(100), allowed.Load())
+}
+
+func TestKeyedLimiter(t *testing.T) {
+	clock := newFakeClock()
+	keyed := NewKeyedLimiter[string](KeyedLimiterOptions{
+		Rate:        10,
+		Burst:       1,
+		IdleTimeout: time.Second,
+		Clock:       clock,
+	})
+
+	// 各键独立
+	assert.True(t, keyed.Allow("a"))
+	assert.False(t, keyed.Allow("a"))
+	assert.True(t, keyed.Allow("b"))
+	assert.False(t, keyed.AllowN("c", 2))
+	assert.NoError(t, keyed.Wait(context.Background(), "d"))
+	assert.Equal(t, 4, keyed.Length())
+
+	// 桶还没满够IdleTimeout，不淘汰
+	clock.Advance(500 * time.Millisecond)
+	assert.Equal(t, 0, keyed.Evict())
+
+	// 访问时顺带清理空闲的键
+	clock.Advance(time.Second)
+	assert.True(t, keyed.Allow("a"))
+	assert.Equal(t, 1, keyed.Length())
+	assert.False(t, keyed.Allow("a"))
+}
+
+func TestSlidingWindowLimiter_Allow(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewSlidingWindowLimiter(SlidingWindowLimiterOptions{Limit: 10, Window: time.Second, Clock: clock})
+
+	assert.True(t, limiter.AllowN(4))
+	for i := 0; i < 6; i++ {
+		assert.True(t, limiter.Allow())
+	}
+	assert.False(t, limiter.Allow())
+	assert.False(t, limiter.AllowN(11))
+	assert.True(t, limiter.AllowN(0))
+
+	// 新窗口刚开始，上一个窗口的请求全部计入
+	clock.Advance(time.Second)
+	assert.False(t, limiter.Allow())
+
+	// 窗口过半，上一个窗口计入一半
+	clock.Advance(500 * time.Millisecond)
+	assert.False(t, limiter.AllowN(6))
+	assert.True(t, limiter.AllowN(5))
+	assert.False(t, limiter.Allow())
+
+	// 上一个窗口不再计入
+	clock.Advance(500 * time.Millisecond)
+	assert.True(t, limiter.AllowN(5))
+	assert.False(t, limiter.Allow())
+
+	// 空闲多个窗口后，计数清零
+	clock.Advance(2 * time.Second)
+	assert.True(t, limiter.AllowN(10))
+}
+
+func TestSlidingWindowLimiter_Wait(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewSlidingWindowLimiter(SlidingWindowLimiterOptions{Limit: 2, Window: time.Second, Clock: clock})
+
+	assert.NoError(t, limiter.Wait(context.Background()))
+	assert.NoError(t, limiter.Wait(context.Background()))
+	assert.Equal(t, ErrExceedsBurst, limiter.WaitN(context.Background(), 3))
+
+	done := make(chan error)
+	go func() {
+		done <- limiter.Wait(context.Background())
+	}()
+
+	// 先等到下一个窗口，再等上一个窗口的计数减半
+	for clock.Sleepers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Second)
+	for clock.Sleepers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatal("should wait for the previous window")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(500 * time.Millisecond)
+	assert.NoError(t, <-done)
+	assert.False(t, limiter.Allow())
+
+	// 截止时间之前等不到
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, limiter.Wait(ctx))
+
+	// 取消
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		done <- limiter.Wait(ctx)
+	}()
+	for clock.Sleepers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
+
+func TestSlidingWindowLimiter_Concurrently(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewSlidingWindowLimiter(SlidingWindowLimiterOptions{Limit: 100, Window: time.Minute, Clock: clock})
+
+	// 时间不动时，恰好放行Limit个
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if limiter.Allow() {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(100), allowed.Load())
+}
+
+func TestUnpackSlidingWindow(t *testing.T) {
+	state := packSlidingWindow(7, 3, 5)
+
+	index, elapsed, prev, curr := unpackSlidingWindow(state, 7, 10)
+	assert.Equal(t, []int64{7, 10, 3, 5}, []int64{int64(index), elapsed, prev, curr})
+
+	index, elapsed, prev, curr = unpackSlidingWindow(state, 8, 10)
+	assert.Equal(t, []int64{8, 10, 5, 0}, []int64{int64(index), elapsed, prev, curr})
+
+	index, elapsed, prev, curr = unpackSlidingWindow(state, 9, 10)
+	assert.Equal(t, []int64{9, 10, 0, 0}, []int64{int64(index), elapsed, prev, curr})
+
+	// 不回退到更早的窗口
+	index, elapsed, prev, curr = unpackSlidingWindow(state, 6, 10)
+	assert.Equal(t, []int64{7, 0, 3, 5}, []int64{int64(index), elapsed, prev, curr})
+
+	// 序号回绕
+	state = packSlidingWindow(1<<32-1, 3, 5)
+	index, _, prev, curr = unpackSlidingWindow(state, 0, 10)
+	assert.Equal(t, []int64{0, 5, 0}, []int64{int64(index), prev, curr})
+}
@@ -0,0 +1,158 @@
+package freesync
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// RWMutex 读多写少场景的读写锁。零值可用，用法同sync.RWMutex。
+// 读者计数分散在各处理器对应的分片中，RLock、RUnlock互不竞争。
+// 写优先：写者等待时，新的读者让路，等待写者结束。
+// 同sync.RWMutex，不能递归读锁定。
+type RWMutex struct {
+	// w 写者之间互斥。读者让路时在这里等待写者结束。
+	w sync.Mutex
+
+	// writer 有写者等待或者持有锁。
+	writer atomic.Bool
+
+	// base 没有竞争时的读者计数。
+	base atomic.Int64
+
+	// cells 出现竞争后创建的读者计数分片。
+	// RUnlock不一定减少RLock增加的分片，单个分片可能为负，只有总和有意义。
+	cells atomic.Pointer[[]counterCell]
+
+	hint shardHint
+
+	// wake 写者等待读者离开。写者设置writer前创建。
+	wake chan struct{}
+}
+
+// readerSlot 读者增加的计数。
+func (rw *RWMutex) readerSlot() *atomic.Int64 {
+	cells := rw.cells.Load()
+	if cells == nil {
+		return &rw.base
+	}
+	return &(*cells)[rw.hint.shard(len(*cells))].value
+}
+
+// arrive 读者计数加1，返回增加的计数。
+func (rw *RWMutex) arrive() *atomic.Int64 {
+	if rw.cells.Load() == nil {
+		old := rw.base.Load()
+		if rw.base.CompareAndSwap(old, old+1) {
+			return &rw.base
+		}
+		// 出现竞争，启用分片
+		newCells := make([]counterCell, runtime.GOMAXPROCS(0))
+		rw.cells.CompareAndSwap(nil, &newCells)
+	}
+	slot := rw.readerSlot()
+	slot.Add(1)
+	return slot
+}
+
+// readers 读者计数的总和。
+// 设置writer之后调用：总和为0时，一定没有持有读锁的读者。
+func (rw *RWMutex) readers() int64 {
+	total := rw.base.Load()
+	if cells := rw.cells.Load(); cells != nil {
+		for i := range *cells {
+			total += (*cells)[i].value.Load()
+		}
+	}
+	return total
+}
+
+// RLock 读锁定。
+func (rw *RWMutex) RLock() {
+	for !rw.TryRLock() {
+		// 等待写者结束
+		rw.w.Lock()
+		rw.w.Unlock()
+	}
+}
+
+// TryRLock 尝试读锁定，不等待。有写者时返回false。
+func (rw *RWMutex) TryRLock() bool {
+	if rw.writer.Load() {
+		return false
+	}
+	slot := rw.arrive()
+	if rw.writer.Load() {
+		// 让路。在同一个计数上撤销，写者不会只看到撤销
+		rw.leave(slot)
+		return false
+	}
+	return true
+}
+
+// RUnlock 解除读锁定。
+func (rw *RWMutex) RUnlock() {
+	rw.leave(rw.readerSlot())
+}
+
+// leave 读者计数减1。有写者等待时，唤醒它。
+func (rw *RWMutex) leave(slot *atomic.Int64) {
+	slot.Add(-1)
+	if rw.writer.Load() {
+		select {
+		case rw.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Lock 写锁定。等待已有的读者离开。
+func (rw *RWMutex) Lock() {
+	rw.w.Lock()
+	rw.acquire()
+	for rw.readers() != 0 {
+		<-rw.wake
+	}
+}
+
+// TryLock 尝试写锁定，不等待。有读者或者写者时返回false。
+func (rw *RWMutex) TryLock() bool {
+	if !rw.w.TryLock() {
+		return false
+	}
+	rw.acquire()
+	if rw.readers() != 0 {
+		rw.writer.Store(false)
+		rw.w.Unlock()
+		return false
+	}
+	return true
+}
+
+// acquire 持有w后，宣告写者，之后的读者让路。
+func (rw *RWMutex) acquire() {
+	if rw.wake == nil {
+		rw.wake = make(chan struct{}, 1)
+	}
+	rw.writer.Store(true)
+}
+
+// Unlock 解除写锁定。
+func (rw *RWMutex) Unlock() {
+	if !rw.writer.Load() {
+		panic("unlock of unlocked RWMutex.")
+	}
+	rw.writer.Store(false)
+	rw.w.Unlock()
+}
+
+// RLocker 返回以RLock、RUnlock实现的sync.Locker。
+func (rw *RWMutex) RLocker() sync.Locker {
+	return (*rlocker)(rw)
+}
+
+// rlocker 读锁的sync.Locker。
+type rlocker RWMutex
+
+func (r *rlocker) Lock()   { (*RWMutex)(r).RLock() }
+func (r *rlocker) Unlock() { (*RWMutex)(r).RUnlock() }
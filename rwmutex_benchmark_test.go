@@ -0,0 +1,74 @@
+package freesync
+
+import (
+	"sync"
+	"testing"
+)
+
+func BenchmarkRWMutexRLock(b *testing.B) {
+	var mu RWMutex
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			mu.RLock()
+			mu.RUnlock()
+		}
+	})
+}
+
+func BenchmarkSyncRWMutexRLock(b *testing.B) {
+	var mu sync.RWMutex
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			mu.RLock()
+			mu.RUnlock()
+		}
+	})
+}
+
+func BenchmarkRWMutexReadMostly(b *testing.B) {
+	var mu RWMutex
+	var value int
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		var i int
+		for p.Next() {
+			i++
+			if i%100 == 0 {
+				mu.Lock()
+				value++
+				mu.Unlock()
+			} else {
+				mu.RLock()
+				_ = value
+				mu.RUnlock()
+			}
+		}
+	})
+}
+
+func BenchmarkSyncRWMutexReadMostly(b *testing.B) {
+	var mu sync.RWMutex
+	var value int
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		var i int
+		for p.Next() {
+			i++
+			if i%100 == 0 {
+				mu.Lock()
+				value++
+				mu.Unlock()
+			} else {
+				mu.RLock()
+				_ = value
+				mu.RUnlock()
+			}
+		}
+	})
+}
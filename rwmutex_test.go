@@ -0,0 +1,105 @@
+package freesync
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRWMutex(t *testing.T) {
+	var mu RWMutex
+
+	mu.RLock()
+	mu.RLock()
+	assert.True(t, mu.TryRLock())
+	assert.False(t, mu.TryLock())
+	mu.RUnlock()
+	mu.RUnlock()
+	mu.RUnlock()
+
+	assert.True(t, mu.TryLock())
+	assert.False(t, mu.TryRLock())
+	assert.False(t, mu.TryLock())
+	mu.Unlock()
+
+	mu.Lock()
+	mu.Unlock()
+	assert.Panics(t, func() {
+		mu.Unlock()
+	})
+
+	locker := mu.RLocker()
+	locker.Lock()
+	assert.False(t, mu.TryLock())
+	locker.Unlock()
+	assert.True(t, mu.TryLock())
+	mu.Unlock()
+}
+
+func TestRWMutex_WriterPreference(t *testing.T) {
+	var mu RWMutex
+	mu.RLock()
+
+	locked := make(chan struct{})
+	go func() {
+		mu.Lock()
+		close(locked)
+		mu.Unlock()
+	}()
+	for !mu.writer.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 写者等待时，新的读者让路
+	assert.False(t, mu.TryRLock())
+	select {
+	case <-locked:
+		t.Fatal("writer should wait for the reader")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	mu.RUnlock()
+	<-locked
+	assert.True(t, mu.TryRLock())
+	mu.RUnlock()
+}
+
+func TestRWMutex_Concurrently(t *testing.T) {
+	var mu RWMutex
+	var readers, writers atomic.Int64
+	var value, sum int
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j++ {
+				if (i+j)%10 == 0 {
+					mu.Lock()
+					assert.Equal(t, int64(0), readers.Load())
+					assert.Equal(t, int64(1), writers.Add(1))
+					value++
+					sum += value
+					writers.Add(-1)
+					mu.Unlock()
+				} else {
+					mu.RLock()
+					readers.Add(1)
+					assert.Equal(t, int64(0), writers.Load())
+					_ = value
+					readers.Add(-1)
+					mu.RUnlock()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 2000, value)
+	assert.Equal(t, 2000*2001/2, sum)
+}
@@ -0,0 +1,186 @@
+package freesync
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/wencan/freesync/lockfree"
+)
+
+// 等待者的状态。
+const (
+	semaphoreWaiting int32 = iota
+	semaphoreGranted
+	semaphoreCanceled
+)
+
+// semaphoreWaiter 排队等待许可的过程。
+type semaphoreWaiter struct {
+	n int64
+
+	// state 等待、已获得许可或者已取消。获得许可和取消通过CAS竞争，只有一个会成功。
+	state atomic.Int32
+
+	// ready 获得许可后关闭。
+	ready chan struct{}
+}
+
+// Semaphore 带权重的信号量。
+// 许可足够且没有排队的等待者时，Acquire只需一次CAS。
+// 否则在无锁链表中按先来后到排队；释放的许可总是先满足排在最前面的等待者，
+// 即使后面有需要更少许可的等待者，也不会插队，避免需要许可多的等待者饿死。
+type Semaphore struct {
+	// size 许可总数。
+	size int64
+
+	// available 可用的许可数。
+	available atomic.Int64
+
+	// waiters 排队中、没有取消的等待者数量。大于0时，新的Acquire不能直接拿走许可。
+	waiters atomic.Int64
+
+	// queue 等待者队列。取消的等待者由唤醒过程移除。
+	queue *lockfree.SinglyLinkedList
+
+	// waking 是否有过程正在唤醒等待者。同一时刻只有一个过程从队首分配许可。
+	waking atomic.Bool
+}
+
+// NewSemaphore 新建一个有size个许可的信号量。
+func NewSemaphore(size int64) *Semaphore {
+	if size < 0 {
+		panic("size must be non-negative.")
+	}
+	semaphore := &Semaphore{
+		size:  size,
+		queue: lockfree.NewSinglyLinkedList(),
+	}
+	semaphore.available.Store(size)
+	return semaphore
+}
+
+// TryAcquire 尝试获得n个许可，不等待。许可不足或者有过程在排队时，返回false。
+func (semaphore *Semaphore) TryAcquire(n int64) bool {
+	if n < 0 {
+		panic("n must be non-negative.")
+	}
+	if semaphore.waiters.Load() > 0 {
+		return false
+	}
+	for {
+		available := semaphore.available.Load()
+		if available < n {
+			return false
+		}
+		if semaphore.available.CompareAndSwap(available, available-n) {
+			return true
+		}
+	}
+}
+
+// Acquire 获得n个许可，许可不足时排队等待，直到ctx结束。
+// ctx结束时返回ctx.Err()，不占用许可。ctx已经结束时，如果许可足够，依然可能成功。
+func (semaphore *Semaphore) Acquire(ctx context.Context, n int64) error {
+	if semaphore.TryAcquire(n) {
+		return nil
+	}
+
+	waiter := &semaphoreWaiter{
+		n:     n,
+		ready: make(chan struct{}),
+	}
+	semaphore.waiters.Add(1)
+	semaphore.queue.RightPush(waiter)
+	// 排队前许可可能已经释放了
+	semaphore.wake()
+
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-ctx.Done():
+		if waiter.state.CompareAndSwap(semaphoreWaiting, semaphoreCanceled) {
+			semaphore.waiters.Add(-1)
+			// 取消的可能是队首，后面的等待者也许可以满足了
+			semaphore.wake()
+			return ctx.Err()
+		}
+		// 同时获得了许可
+		<-waiter.ready
+		return nil
+	}
+}
+
+// Release 释放n个许可。释放的许可多于占用的许可时，panic。
+func (semaphore *Semaphore) Release(n int64) {
+	if n < 0 {
+		panic("n must be non-negative.")
+	}
+	if semaphore.available.Add(n) > semaphore.size {
+		panic("semaphore: released more than held")
+	}
+	// 之后才排队的等待者，排队后会自己检查
+	if semaphore.waiters.Load() > 0 {
+		semaphore.wake()
+	}
+}
+
+// wake 按顺序满足队首的等待者。
+func (semaphore *Semaphore) wake() {
+	for {
+		if !semaphore.waking.CompareAndSwap(false, true) {
+			// 其它过程正在唤醒，它结束后会重新检查
+			return
+		}
+		for semaphore.grantHead() {
+		}
+		semaphore.waking.Store(false)
+
+		// 唤醒期间，其它过程可能释放了许可、排队或者取消了，它们没能取得唤醒权
+		if !semaphore.headReady() {
+			return
+		}
+	}
+}
+
+// grantHead 满足队首的等待者，或者移除已取消的队首。队首没有变化时，返回false。
+// 调用者需要取得唤醒权。
+func (semaphore *Semaphore) grantHead() bool {
+	p := semaphore.queue.LeftPeek()
+	if p == nil {
+		return false
+	}
+	waiter := p.(*semaphoreWaiter)
+	if waiter.state.Load() == semaphoreCanceled {
+		semaphore.queue.LeftPop()
+		return true
+	}
+
+	for {
+		available := semaphore.available.Load()
+		if available < waiter.n {
+			return false
+		}
+		if semaphore.available.CompareAndSwap(available, available-waiter.n) {
+			break
+		}
+	}
+	semaphore.queue.LeftPop()
+	if waiter.state.CompareAndSwap(semaphoreWaiting, semaphoreGranted) {
+		semaphore.waiters.Add(-1)
+		close(waiter.ready)
+	} else {
+		// 同时取消了，退还许可
+		semaphore.available.Add(waiter.n)
+	}
+	return true
+}
+
+// headReady 队首是否需要处理：已取消，或者许可已经足够。
+func (semaphore *Semaphore) headReady() bool {
+	p := semaphore.queue.LeftPeek()
+	if p == nil {
+		return false
+	}
+	waiter := p.(*semaphoreWaiter)
+	return waiter.state.Load() == semaphoreCanceled || semaphore.available.Load() >= waiter.n
+}
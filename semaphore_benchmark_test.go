@@ -0,0 +1,31 @@
+package freesync
+
+import (
+	"context"
+	"testing"
+)
+
+func BenchmarkSemaphore(b *testing.B) {
+	semaphore := NewSemaphore(4)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			semaphore.Acquire(ctx, 1)
+			semaphore.Release(1)
+		}
+	})
+}
+
+func BenchmarkChannelSemaphore(b *testing.B) {
+	semaphore := make(chan struct{}, 4)
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			semaphore <- struct{}{}
+			<-semaphore
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package freesync
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSemaphore(t *testing.T) {
+	semaphore := NewSemaphore(3)
+	assert.True(t, semaphore.TryAcquire(2))
+	assert.False(t, semaphore.TryAcquire(2))
+	assert.True(t, semaphore.TryAcquire(1))
+	assert.False(t, semaphore.TryAcquire(1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, semaphore.Acquire(ctx, 1))
+
+	semaphore.Release(3)
+	assert.NoError(t, semaphore.Acquire(context.Background(), 3))
+	semaphore.Release(3)
+
+	assert.Panics(t, func() {
+		semaphore.Release(1)
+	})
+}
+
+func TestSemaphore_FIFO(t *testing.T) {
+	semaphore := NewSemaphore(10)
+	assert.True(t, semaphore.TryAcquire(10))
+
+	// 需要许可多的等待者排在前面，不会被需要许可少的插队
+	var order []int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, n := range []int64{10, 1, 1} {
+		wg.Add(1)
+		go func(i int, n int64) {
+			defer wg.Done()
+
+			assert.NoError(t, semaphore.Acquire(context.Background(), n))
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			semaphore.Release(n)
+		}(i, n)
+		for semaphore.waiters.Load() != int64(i+1) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// 有排队的等待者时，TryAcquire不会插队
+	semaphore.Release(5)
+	assert.False(t, semaphore.TryAcquire(1))
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	assert.Empty(t, order)
+	mu.Unlock()
+
+	semaphore.Release(5)
+	wg.Wait()
+	assert.Equal(t, 0, order[0])
+	assert.True(t, semaphore.TryAcquire(10))
+}
+
+func TestSemaphore_Cancel(t *testing.T) {
+	semaphore := NewSemaphore(2)
+	assert.True(t, semaphore.TryAcquire(2))
+
+	// 取消的队首不会阻塞后面的等待者
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- semaphore.Acquire(ctx, 2)
+	}()
+	for semaphore.waiters.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	acquired := make(chan error)
+	go func() {
+		acquired <- semaphore.Acquire(context.Background(), 1)
+	}()
+	for semaphore.waiters.Load() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	semaphore.Release(1)
+	cancel()
+	assert.Equal(t, context.Canceled, <-errs)
+	assert.NoError(t, <-acquired)
+
+	// 归还最初剩下的1个和等待者获得的1个
+	semaphore.Release(2)
+	assert.True(t, semaphore.TryAcquire(2))
+}
+
+func TestSemaphore_Concurrently(t *testing.T) {
+	const size = 5
+	semaphore := NewSemaphore(size)
+
+	var inUse, maxInUse atomic.Int64
+	var canceled atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 200; j++ {
+				n := int64(j%3 + 1)
+				ctx, cancel := context.WithCancel(context.Background())
+				if (i+j)%7 == 0 {
+					cancel()
+				}
+				err := semaphore.Acquire(ctx, n)
+				cancel()
+				if err != nil {
+					canceled.Add(1)
+					continue
+				}
+
+				current := inUse.Add(n)
+				for {
+					max := maxInUse.Load()
+					if current <= max || maxInUse.CompareAndSwap(max, current) {
+						break
+					}
+				}
+				inUse.Add(-n)
+				semaphore.Release(n)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.True(t, maxInUse.Load() <= size)
+	assert.Equal(t, int64(0), semaphore.waiters.Load())
+	assert.True(t, semaphore.TryAcquire(size))
+}
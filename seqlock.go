@@ -0,0 +1,151 @@
+package freesync
+
+import (
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// SeqLock 顺序锁保护的值。适合读多写少、不便每次写入都分配新对象的大结构，比如统计快照。
+// Load乐观地无锁读取，读到写入中途的数据时重试；Store之间加锁串行。
+// 零值可用，值为T的零值。
+//
+// 值按字用原子操作读写，不会与race detector冲突。因此T不能包含指针，
+// 包括string、slice、map、interface等含指针的类型。第一次写入时检查，T包含指针时panic。
+type SeqLock[T any] struct {
+	// seq 版本号。写入期间为奇数。
+	seq atomic.Uint64
+
+	// words 按字保存的值。第一次写入时按T的大小分配，之后不再变化。为nil表示还没写入过。
+	words atomic.Pointer[[]atomic.Uint64]
+
+	// mu 保证Store之间没有并发。
+	mu sync.Mutex
+}
+
+// NewSeqLock 新建一个顺序锁保护的值。T包含指针时panic。
+func NewSeqLock[T any](value T) *SeqLock[T] {
+	lock := &SeqLock[T]{}
+	lock.Store(value)
+	return lock
+}
+
+// writableWords 写入用的words，第一次写入时检查T并分配。调用者需要持有锁。
+func (lock *SeqLock[T]) writableWords() []atomic.Uint64 {
+	if words := lock.words.Load(); words != nil {
+		return *words
+	}
+
+	var value T
+	if typ := reflect.TypeOf(&value).Elem(); typeHasPointers(typ) {
+		panic("seqlock value must not contain pointers")
+	}
+	words := make([]atomic.Uint64, (unsafe.Sizeof(value)+7)/8)
+	lock.words.Store(&words)
+	return words
+}
+
+// typeHasPointers 类型是否包含指针。
+func typeHasPointers(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return false
+	case reflect.Array:
+		return typ.Len() > 0 && typeHasPointers(typ.Elem())
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			if typeHasPointers(typ.Field(i).Type) {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// Load 读取值。不加锁，与Store并发时重试。
+func (lock *SeqLock[T]) Load() T {
+	var value T
+	for {
+		seq := lock.seq.Load()
+		if seq&1 != 0 {
+			// 正在写入
+			runtime.Gosched()
+			continue
+		}
+		words := lock.words.Load()
+		if words == nil {
+			// 还没写入过
+			return value
+		}
+		readWords(*words, &value)
+		if lock.seq.Load() == seq {
+			return value
+		}
+	}
+}
+
+// Store 写入值。
+func (lock *SeqLock[T]) Store(value T) {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+
+	lock.write(lock.writableWords(), &value)
+}
+
+// Update 在锁内读取、修改并写入值。f不能调用Store、Update。
+func (lock *SeqLock[T]) Update(f func(value *T)) {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+
+	words := lock.writableWords()
+	var value T
+	// 持有锁，没有并发的写入
+	readWords(words, &value)
+	f(&value)
+	lock.write(words, &value)
+}
+
+// valueWords 值的内存。对齐到8字节时，整字部分可以直接按字访问，避免逐字节复制。
+func valueWords[T any](value *T) (words []uint64, tail []byte) {
+	size := unsafe.Sizeof(*value)
+	bytes := unsafe.Slice((*byte)(unsafe.Pointer(value)), size)
+	if unsafe.Alignof(*value) < 8 {
+		return nil, bytes
+	}
+	words = unsafe.Slice((*uint64)(unsafe.Pointer(value)), size/8)
+	return words, bytes[len(words)*8:]
+}
+
+// readWords 从stored逐字读取。可能读到写入中途的数据，需要调用者检查版本号。
+func readWords[T any](stored []atomic.Uint64, value *T) {
+	words, tail := valueWords(value)
+	for i := range words {
+		words[i] = stored[i].Load()
+	}
+	for i := len(words); i < len(stored); i++ {
+		word := stored[i].Load()
+		copy(tail[(i-len(words))*8:], (*[8]byte)(unsafe.Pointer(&word))[:])
+	}
+}
+
+// write 逐字写入stored。调用者需要持有锁。
+func (lock *SeqLock[T]) write(stored []atomic.Uint64, value *T) {
+	words, tail := valueWords(value)
+	lock.seq.Add(1)
+	for i := range words {
+		stored[i].Store(words[i])
+	}
+	for i := len(words); i < len(stored); i++ {
+		var word uint64
+		copy((*[8]byte)(unsafe.Pointer(&word))[:], tail[(i-len(words))*8:])
+		stored[i].Store(word)
+	}
+	lock.seq.Add(1)
+}
@@ -0,0 +1,47 @@
+package freesync
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func BenchmarkSeqLockLoad(b *testing.B) {
+	lock := NewSeqLock(seqLockSnapshot{})
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			_ = lock.Load()
+		}
+	})
+}
+
+func BenchmarkAtomicValueLoad(b *testing.B) {
+	var value atomic.Value
+	value.Store(seqLockSnapshot{})
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			_ = value.Load().(seqLockSnapshot)
+		}
+	})
+}
+
+func BenchmarkSeqLockStore(b *testing.B) {
+	lock := NewSeqLock(seqLockSnapshot{})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lock.Store(seqLockSnapshot{Count: int64(i)})
+	}
+}
+
+func BenchmarkAtomicValueStore(b *testing.B) {
+	var value atomic.Value
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		value.Store(seqLockSnapshot{Count: int64(i)})
+	}
+}
@@ -0,0 +1,142 @@
+package freesync
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type seqLockSnapshot struct {
+	Count   int64
+	Sum     int64
+	Values  [13]int32
+	Flag    bool
+	Average float64
+}
+
+func TestSeqLock(t *testing.T) {
+	lock := NewSeqLock(seqLockSnapshot{Count: 1})
+	assert.Equal(t, seqLockSnapshot{Count: 1}, lock.Load())
+
+	value := seqLockSnapshot{Count: 2, Sum: 3, Flag: true, Average: 1.5}
+	value.Values[12] = 7
+	lock.Store(value)
+	assert.Equal(t, value, lock.Load())
+
+	lock.Update(func(value *seqLockSnapshot) {
+		value.Count++
+	})
+	assert.Equal(t, int64(3), lock.Load().Count)
+
+	// 大小不是8的倍数
+	small := NewSeqLock([3]byte{1, 2, 3})
+	assert.Equal(t, [3]byte{1, 2, 3}, small.Load())
+
+	assert.Panics(t, func() {
+		NewSeqLock("string")
+	})
+	assert.Panics(t, func() {
+		NewSeqLock(struct{ p *int }{})
+	})
+}
+
+func TestSeqLock_ZeroValue(t *testing.T) {
+	var lock SeqLock[[4]int64]
+	assert.Equal(t, [4]int64{}, lock.Load())
+	lock.Store([4]int64{1, 2, 3, 4})
+	assert.Equal(t, [4]int64{1, 2, 3, 4}, lock.Load())
+	lock.Update(func(value *[4]int64) {
+		value[3]++
+	})
+	assert.Equal(t, [4]int64{1, 2, 3, 5}, lock.Load())
+
+	// 小于8字节
+	var small SeqLock[int16]
+	small.Update(func(value *int16) {
+		*value += 7
+	})
+	assert.Equal(t, int16(7), small.Load())
+
+	// 第一次写入时检查指针
+	var invalid SeqLock[string]
+	assert.Equal(t, "", invalid.Load())
+	assert.Panics(t, func() {
+		invalid.Store("string")
+	})
+}
+
+func TestSeqLock_Concurrently(t *testing.T) {
+	// 读到的值总是某次完整写入的值
+	lock := NewSeqLock(seqLockSnapshot{})
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 1; j <= 10000; j++ {
+				lock.Update(func(value *seqLockSnapshot) {
+					value.Count++
+					value.Sum = value.Count * 2
+					for k := range value.Values {
+						value.Values[k] = int32(value.Count)
+					}
+					value.Average = float64(value.Sum) / float64(value.Count)
+				})
+			}
+		}()
+	}
+
+	var readers sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+
+			var last int64
+			for !stop.Load() {
+				value := lock.Load()
+				if !assert.Equal(t, value.Count*2, value.Sum) {
+					return
+				}
+				for _, v := range value.Values {
+					if !assert.Equal(t, int32(value.Count), v) {
+						return
+					}
+				}
+				// 不会倒退
+				if !assert.True(t, value.Count >= last) {
+					return
+				}
+				last = value.Count
+			}
+		}()
+	}
+	wg.Wait()
+	stop.Store(true)
+	readers.Wait()
+
+	assert.Equal(t, int64(4*10000), lock.Load().Count)
+}
+
+func TestSeqLock_Layout(t *testing.T) {
+	// 对齐到8字节，字段之间和末尾有填充
+	type mixed struct {
+		A int64
+		B int32
+		C int16
+		D [3]int8
+	}
+	value := mixed{A: -1, B: 2, C: 3, D: [3]int8{4, 5, 6}}
+	assert.Equal(t, value, NewSeqLock(value).Load())
+
+	// 对齐小于8字节
+	type packed struct {
+		A, B, C int32
+	}
+	assert.Equal(t, packed{1, 2, 3}, NewSeqLock(packed{1, 2, 3}).Load())
+}
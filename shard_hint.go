@@ -0,0 +1,28 @@
+package freesync
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// shardHint 为过程选择分片。零值可用。
+// sync.Pool按处理器缓存对象，同一个处理器上的过程大多拿到同一个分片号。
+type shardHint struct {
+	tokens sync.Pool
+
+	// next 下一个分配的分片号。
+	next atomic.Uint32
+}
+
+// shard 当前过程使用的分片，小于n。
+func (hint *shardHint) shard(n int) int {
+	token, _ := hint.tokens.Get().(*int)
+	if token == nil {
+		// 新处理器，或者缓存被GC清除了。轮流分配分片号
+		index := int(hint.next.Add(1) - 1)
+		token = &index
+	}
+	shard := *token % n
+	hint.tokens.Put(token)
+	return shard
+}
@@ -0,0 +1,80 @@
+package freesync
+
+import (
+	"runtime"
+)
+
+// ShardedBag 分片的并发安全容器。
+// 每个分片是一个独立的Bag，有自己的空闲索引列表。
+// Add优先使用当前处理器对应的分片，多个过程并发Add时基本不会竞争。
+// 返回的索引编码了分片号：index = 分片内索引*分片数 + 分片号。
+type ShardedBag[T any] struct {
+	shards []*Bag
+
+	hint shardHint
+}
+
+// NewShardedBag 新建一个分片的容器。shards不大于0时，分片数等于GOMAXPROCS。
+func NewShardedBag[T any](shards int) *ShardedBag[T] {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+
+	bag := &ShardedBag[T]{
+		shards: make([]*Bag, shards),
+	}
+	for i := range bag.shards {
+		bag.shards[i] = NewBag()
+	}
+	return bag
+}
+
+// Add 添加一个元素，返回索引。
+// 警告：删除元素的索引会被重用。
+func (bag *ShardedBag[T]) Add(value T) int {
+	shard := bag.hint.shard(len(bag.shards))
+	index := bag.shards[shard].Add(value)
+
+	return index*len(bag.shards) + shard
+}
+
+// DeleteAt 删除指定位置上的元素。
+// 警告：删除后，index会被回收重用。
+func (bag *ShardedBag[T]) DeleteAt(index int) {
+	if index < 0 {
+		panic("index must be non-negative.")
+	}
+	bag.shards[index%len(bag.shards)].DeleteAt(index / len(bag.shards))
+}
+
+// Compact 压缩每个分片。有效元素的索引不会改变。
+func (bag *ShardedBag[T]) Compact() {
+	for _, sub := range bag.shards {
+		sub.Compact()
+	}
+}
+
+// Range 遍历。逐个分片遍历，不是按索引顺序。
+func (bag *ShardedBag[T]) Range(f func(index int, value T) (stopIteration bool)) {
+	var stop bool
+	for shard, sub := range bag.shards {
+		sub.Range(func(index int, p interface{}) (stopIteration bool) {
+			// T是接口类型时，存入的nil取出来是无类型的nil
+			value, _ := p.(T)
+			stop = f(index*len(bag.shards)+shard, value)
+			return stop
+		})
+		if stop {
+			return
+		}
+	}
+}
+
+// Length 长度。
+func (bag *ShardedBag[T]) Length() int {
+	var length int
+	for _, sub := range bag.shards {
+		length += sub.Length()
+	}
+	return length
+}
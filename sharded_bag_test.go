@@ -0,0 +1,98 @@
+package freesync
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedBag(t *testing.T) {
+	bag := NewShardedBag[int](4)
+
+	indexes := make(map[int]int)
+	for i := 0; i < 100; i++ {
+		index := bag.Add(i)
+		_, exists := indexes[index]
+		assert.False(t, exists)
+		indexes[index] = i
+	}
+	assert.Equal(t, 100, bag.Length())
+
+	for index, value := range indexes {
+		if value%10 == 0 {
+			bag.DeleteAt(index)
+		}
+	}
+	assert.Equal(t, 90, bag.Length())
+
+	bag.Range(func(index int, value int) (stopIteration bool) {
+		assert.Equal(t, indexes[index], value)
+		assert.NotEqual(t, 0, value%10)
+		return false
+	})
+
+	var count int
+	bag.Range(func(index int, value int) (stopIteration bool) {
+		count++
+		return count == 5
+	})
+	assert.Equal(t, 5, count)
+}
+
+func TestShardedBag_NilInterface(t *testing.T) {
+	bag := NewShardedBag[error](2)
+	bag.Add(nil)
+	bag.Add(errors.New("failed"))
+
+	var nils, errs int
+	bag.Range(func(index int, value error) (stopIteration bool) {
+		if value == nil {
+			nils++
+		} else {
+			errs++
+		}
+		return false
+	})
+	assert.Equal(t, 1, nils)
+	assert.Equal(t, 1, errs)
+}
+
+func TestShardedBag_ConcurrentlyAddAndDelete(t *testing.T) {
+	bag := NewShardedBag[int](0)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var kept []int
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 5000; j++ {
+				value := i*5000 + j
+				index := bag.Add(value)
+				if j%2 == 0 {
+					bag.DeleteAt(index)
+				} else {
+					mu.Lock()
+					kept = append(kept, value)
+					mu.Unlock()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, len(kept), bag.Length())
+	var all []int
+	bag.Range(func(index int, value int) (stopIteration bool) {
+		all = append(all, value)
+		return false
+	})
+	sort.Ints(all)
+	sort.Ints(kept)
+	assert.Equal(t, kept, all)
+}
@@ -0,0 +1,90 @@
+package freesync
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError 共享的函数调用panic时，所有等待这次调用的过程都以它panic。
+type PanicError struct {
+	// Value 原始的panic值。
+	Value interface{}
+
+	// Stack panic时的调用栈。
+	Stack []byte
+}
+
+// Error 实现error接口。
+func (err *PanicError) Error() string {
+	return fmt.Sprintf("%v\n\n%s", err.Value, err.Stack)
+}
+
+// Unwrap 原始的panic值是error时，返回它。
+func (err *PanicError) Unwrap() error {
+	if e, ok := err.Value.(error); ok {
+		return e
+	}
+	return nil
+}
+
+// ErrGoexit 共享的函数调用了runtime.Goexit。等待这次调用的其它过程得到这个错误。
+var ErrGoexit = errors.New("runtime.Goexit was called")
+
+// sharedCall 一次由多个过程共享结果的函数调用。
+type sharedCall[V any] struct {
+	// done 调用结束后关闭。之后value、err、panicErr不再变化。
+	done chan struct{}
+
+	value    V
+	err      error
+	panicErr *PanicError
+}
+
+// newSharedCall 新建一次还没执行的调用。
+func newSharedCall[V any]() sharedCall[V] {
+	return sharedCall[V]{done: make(chan struct{})}
+}
+
+// result 等待调用结束，返回结果。调用panic时，以PanicError panic。
+func (call *sharedCall[V]) result() (V, error) {
+	<-call.done
+	if call.panicErr != nil {
+		panic(call.panicErr)
+	}
+	return call.value, call.err
+}
+
+// run 执行fn，保存结果，通知等待者。finish在通知等待者之前调用，用于登记或者撤销结果。
+// 同x/sync/singleflight，用normalReturn区分正常返回、panic和runtime.Goexit。
+func (call *sharedCall[V]) run(fn func() (V, error), finish func()) {
+	normalReturn := false
+	recovered := false
+
+	defer func() {
+		if !normalReturn && !recovered {
+			// fn调用了runtime.Goexit，不能当作成功
+			call.err = ErrGoexit
+		}
+		finish()
+		close(call.done)
+	}()
+
+	func() {
+		defer func() {
+			if !normalReturn {
+				// runtime.Goexit时recover返回nil
+				if r := recover(); r != nil {
+					call.panicErr = &PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}
+		}()
+
+		call.value, call.err = fn()
+		normalReturn = true
+	}()
+
+	if !normalReturn {
+		recovered = true
+	}
+}
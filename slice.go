@@ -7,75 +7,121 @@ import (
 	"github.com/wencan/freesync/lockfree"
 )
 
+// deletedSliceEntry DeleteAt留下的墓碑。
+var deletedSliceEntry = new(interface{})
+
 // Slice 并发安全的Slice结构。
+// Append、Load、UpdateAt、DeleteAt、Range不加锁，增长也不加锁；Pop、Truncate、Compact加锁。
 type Slice struct {
 	// mux 锁。
 	mu sync.Mutex
 
 	// store 实质存储数据。内部结构为*lockfree.Slice。
-	// slice增长时，需要加锁。
+	// slice缩短时，需要加锁。
 	store atomic.Value
+
+	// padded 是否每个元素独占一个缓存行。
+	padded bool
+}
+
+// NewPaddedSlice 新建一个每个元素独占一个缓存行的Slice。
+// 多个过程频繁更新相邻下标时，可以避免伪共享。内存占用是零值Slice的两倍多。
+func NewPaddedSlice() *Slice {
+	return &Slice{padded: true}
 }
 
 // Append 在末尾追加一个元素。返回下标。
 func (slice *Slice) Append(p interface{}) int {
 	store, _ := slice.store.Load().(*lockfree.Slice)
 	if store != nil {
-		if index, ok := store.Append(p); ok {
-			return index
+		for {
+			if index, ok := store.Append(p); ok {
+				return index
+			}
+			// 增长无锁。增长被封闭时，正在Pop、Truncate或者Compact，加锁等待
+			if _, added := store.Grow(); added == 0 {
+				break
+			}
 		}
 	}
 
 	slice.mu.Lock()
 	defer slice.mu.Unlock()
 
+	store, _ = slice.store.Load().(*lockfree.Slice)
 	if store == nil {
 		// 初始化
-		store, _ = slice.store.Load().(*lockfree.Slice)
-		if store == nil {
-			store = &lockfree.Slice{}
-			slice.store.Store(store)
+		store = slice.newStore()
+		slice.store.Store(store)
+	}
+	for {
+		if index, ok := store.Append(p); ok {
+			return index
 		}
-	} else {
-		previous := store
-		store = slice.store.Load().(*lockfree.Slice)
-		if store != previous {
-			if index, ok := store.Append(p); ok {
-				return index
-			}
+		// 持有锁时，最新的存储不会封闭增长
+		if _, added := store.Grow(); added == 0 {
+			panic("impossibility")
 		}
 	}
+}
 
-	// 增加容量后再append
-	newStore, _ := store.Grow()
-	index, ok := newStore.Append(p)
-	if !ok {
-		panic("impossibility")
+// NewSliceWithCapacity 新建一个预留了至少capacity容量的Slice。
+func NewSliceWithCapacity(capacity int) *Slice {
+	slice := &Slice{}
+	slice.Reserve(capacity)
+	return slice
+}
+
+// Reserve 预留容量，保证至少能存放capacity个元素，不再需要逐步增长。
+// 一次创建全部需要的空间。Pop、Truncate会释放截断位置之后的预留容量。
+func (slice *Slice) Reserve(capacity int) {
+	slice.mu.Lock()
+	defer slice.mu.Unlock()
+
+	store, _ := slice.store.Load().(*lockfree.Slice)
+	if store == nil {
+		store = slice.newStore()
+		slice.store.Store(store)
 	}
-	slice.store.Store(newStore)
+	store.GrowTo(capacity)
+}
 
-	return index
+// newStore 新建一个空的存储。
+func (slice *Slice) newStore() *lockfree.Slice {
+	if slice.padded {
+		return lockfree.NewPaddedSlice()
+	}
+	return &lockfree.Slice{}
 }
 
-// Load 取得下标位置上的值。
+// Load 取得下标位置上的值。已删除的位置返回nil。
 func (slice *Slice) Load(index int) interface{} {
 	store, _ := slice.store.Load().(*lockfree.Slice)
 	if store == nil {
 		panic("empty slice")
 	}
-	return store.Load(index)
+	p := store.Load(index)
+	if p == deletedSliceEntry {
+		return nil
+	}
+	return p
 }
 
-// Range 遍历。
+// Range 遍历。跳过已删除的位置。
 func (slice *Slice) Range(f func(index int, p interface{}) (stopIteration bool)) {
 	store, _ := slice.store.Load().(*lockfree.Slice)
 	if store == nil {
 		return
 	}
-	store.Range(f)
+	store.Range(func(index int, p interface{}) (stopIteration bool) {
+		if p == deletedSliceEntry {
+			return false
+		}
+		return f(index, p)
+	})
 }
 
-// Length 长度。
+// Length 长度。不包括已删除的位置。
 func (slice *Slice) Length() int {
 	var length int
 	slice.Range(func(index int, p interface{}) (stopIteration bool) {
@@ -85,11 +131,101 @@ func (slice *Slice) Length() int {
 	return length
 }
 
-// UpdateAt 更新下标位置上的值，返回旧值。
+// UpdateAt 更新下标位置上的值，返回旧值。位置已删除时，旧值为nil。
 func (slice *Slice) UpdateAt(index int, p interface{}) (old interface{}) {
 	store, _ := slice.store.Load().(*lockfree.Slice)
 	if store == nil {
 		panic("empty slice")
 	}
-	return store.UpdateAt(index, p)
+	old = store.UpdateAt(index, p)
+	if old == deletedSliceEntry {
+		return nil
+	}
+	return old
+}
+
+// DeleteAt 逻辑删除下标位置上的值，返回旧值。位置保留，下标不变。
+// 之后Load返回nil，Range跳过这个位置。UpdateAt可以重新设置这个位置。
+func (slice *Slice) DeleteAt(index int) (old interface{}) {
+	store, _ := slice.store.Load().(*lockfree.Slice)
+	if store == nil {
+		panic("empty slice")
+	}
+	old = store.UpdateAt(index, deletedSliceEntry)
+	if old == deletedSliceEntry {
+		return nil
+	}
+	return old
+}
+
+// Pop 删除并返回最后一个值。末尾已删除的位置一并去掉。如果没有值，返回false。
+// 线性化点是封闭末尾LimitedSlice的时刻：之前开始的Append都算在Pop之前，之后的Append等Pop结束后重试。
+// 不能与被截掉位置上的UpdateAt、DeleteAt并发。
+func (slice *Slice) Pop() (p interface{}, ok bool) {
+	slice.mu.Lock()
+	defer slice.mu.Unlock()
+
+	store, _ := slice.store.Load().(*lockfree.Slice)
+	if store == nil {
+		return nil, false
+	}
+	defer func() {
+		slice.store.Store(store)
+	}()
+
+	for {
+		p, ok, store = store.Pop()
+		if !ok {
+			return nil, false
+		}
+		if p != deletedSliceEntry {
+			return p, true
+		}
+	}
+}
+
+// Truncate 只保留前length个位置。length不小于长度时，不截断。
+// 线性化点和并发限制同Pop。
+func (slice *Slice) Truncate(length int) {
+	if length < 0 {
+		panic("length must be non-negative.")
+	}
+
+	slice.mu.Lock()
+	defer slice.mu.Unlock()
+
+	store, _ := slice.store.Load().(*lockfree.Slice)
+	if store == nil {
+		return
+	}
+	slice.store.Store(store.Truncate(length))
+}
+
+// Compact 去掉已删除的位置，保持其余值的顺序。之后的值下标会前移。
+// Append等Compact结束后进行；与UpdateAt、DeleteAt并发时，这些更新可能丢失。
+func (slice *Slice) Compact() {
+	slice.mu.Lock()
+	defer slice.mu.Unlock()
+
+	store, _ := slice.store.Load().(*lockfree.Slice)
+	if store == nil {
+		return
+	}
+
+	// 封闭，之后的Append失败，等Compact结束后重试。已经开始的Append写入完成
+	store.Seal()
+	var values []interface{}
+	store.Range(func(index int, p interface{}) (stopIteration bool) {
+		if p != deletedSliceEntry {
+			values = append(values, p)
+		}
+		return false
+	})
+
+	newStore := slice.newStore()
+	newStore.GrowTo(len(values))
+	for _, p := range values {
+		newStore.Append(p)
+	}
+	slice.store.Store(newStore)
 }
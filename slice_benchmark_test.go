@@ -2,6 +2,7 @@ package freesync
 
 import (
 	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -18,6 +19,20 @@ func BenchmarkSlice_Append(b *testing.B) {
 	})
 }
 
+func BenchmarkSlice_AppendReserved(b *testing.B) {
+	slice := NewSliceWithCapacity(b.N)
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		var i int
+		for p.Next() {
+			slice.Append(i)
+
+			i++
+		}
+	})
+}
+
 func BenchmarkMutexSlice_Append(b *testing.B) {
 	var slice []int
 	var mu sync.Mutex
@@ -184,3 +199,101 @@ func BenchmarkRWMutexSlice_Load(b *testing.B) {
 		}
 	})
 }
+
+// sliceLayouts 紧凑布局和每个元素独占缓存行的布局。
+var sliceLayouts = []struct {
+	name     string
+	newSlice func() *Slice
+}{
+	{"Dense", func() *Slice { return &Slice{} }},
+	{"Padded", NewPaddedSlice},
+}
+
+// BenchmarkSliceLayout 比较两种布局。
+// Neighbouring：每个过程反复更新自己的下标，下标彼此相邻，紧凑布局下共享缓存行。
+// Spread：所有过程轮流更新全部下标。
+// Load：所有过程轮流读取全部下标。
+// AppendAndLoad：一半过程并发追加，另一半过程读取第一个元素。
+func BenchmarkSliceLayout(b *testing.B) {
+	for _, layout := range sliceLayouts {
+		layout := layout
+
+		b.Run(layout.name+"/Neighbouring", func(b *testing.B) {
+			slice := layout.newSlice()
+			for i := 0; i < 1024; i++ {
+				slice.Append(i)
+			}
+			var nextIndex int64 = -1
+
+			b.ResetTimer()
+			b.RunParallel(func(p *testing.PB) {
+				index := int(atomic.AddInt64(&nextIndex, 1)) % 1024
+				var i int
+				for p.Next() {
+					slice.UpdateAt(index, i)
+					i++
+				}
+			})
+		})
+
+		b.Run(layout.name+"/Spread", func(b *testing.B) {
+			slice := layout.newSlice()
+			for i := 0; i < 10000; i++ {
+				slice.Append(i)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(p *testing.PB) {
+				var i int
+				for p.Next() {
+					if i >= 10000 {
+						i = 0
+					}
+					slice.UpdateAt(i, i)
+					i++
+				}
+			})
+		})
+
+		b.Run(layout.name+"/Load", func(b *testing.B) {
+			slice := layout.newSlice()
+			for i := 0; i < 10000; i++ {
+				slice.Append(i)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(p *testing.PB) {
+				var i int
+				for p.Next() {
+					if i >= 10000 {
+						i = 0
+					}
+					slice.Load(i)
+					i++
+				}
+			})
+		})
+
+		b.Run(layout.name+"/AppendAndLoad", func(b *testing.B) {
+			slice := layout.newSlice()
+			slice.Append(0)
+			var nextIndex int64
+
+			b.ResetTimer()
+			b.RunParallel(func(p *testing.PB) {
+				// 一半过程追加，一半过程读取第一个元素
+				if atomic.AddInt64(&nextIndex, 1)%2 == 0 {
+					for p.Next() {
+						slice.Load(0)
+					}
+					return
+				}
+				var i int
+				for p.Next() {
+					slice.Append(i)
+					i++
+				}
+			})
+		})
+	}
+}
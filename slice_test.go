@@ -247,3 +247,221 @@ func TestSlice_concurrentlyAppendAndRange(t *testing.T) {
 	}
 	wg2.Wait()
 }
+
+func TestPaddedSlice(t *testing.T) {
+	slice := NewPaddedSlice()
+
+	var wg sync.WaitGroup
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j++ {
+				index := slice.Append(j)
+				slice.UpdateAt(index, index)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 10000, slice.Length())
+	slice.Range(func(index int, p interface{}) (stopIteration bool) {
+		assert.Equal(t, index, p)
+		return false
+	})
+}
+
+func TestSlice_Pop(t *testing.T) {
+	var slice Slice
+	_, ok := slice.Pop()
+	assert.False(t, ok)
+
+	for i := 0; i < 1000; i++ {
+		slice.Append(i)
+	}
+	for i := 999; i >= 500; i-- {
+		p, ok := slice.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, i, p)
+	}
+	assert.Equal(t, 500, slice.Length())
+
+	// 下标接着Pop后的长度
+	assert.Equal(t, 500, slice.Append(500))
+
+	for i := 500; i >= 0; i-- {
+		p, ok := slice.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, i, p)
+	}
+	_, ok = slice.Pop()
+	assert.False(t, ok)
+	assert.Equal(t, 0, slice.Length())
+	assert.Equal(t, 0, slice.Append(0))
+}
+
+func TestSlice_Truncate(t *testing.T) {
+	var slice Slice
+	slice.Truncate(10)
+
+	for i := 0; i < 1000; i++ {
+		slice.Append(i)
+	}
+	slice.Truncate(2000)
+	assert.Equal(t, 1000, slice.Length())
+
+	slice.Truncate(300)
+	assert.Equal(t, 300, slice.Length())
+	assert.Equal(t, 300, slice.Append(300))
+	slice.Range(func(index int, p interface{}) (stopIteration bool) {
+		assert.Equal(t, index, p)
+		return false
+	})
+
+	slice.Truncate(0)
+	assert.Equal(t, 0, slice.Length())
+	assert.Equal(t, 0, slice.Append(0))
+}
+
+func TestSlice_DeleteAtAndCompact(t *testing.T) {
+	var slice Slice
+	for i := 0; i < 100; i++ {
+		slice.Append(i)
+	}
+	for i := 0; i < 100; i += 3 {
+		assert.Equal(t, i, slice.DeleteAt(i))
+	}
+	assert.Nil(t, slice.DeleteAt(0))
+	assert.Nil(t, slice.Load(0))
+	assert.Equal(t, 1, slice.Load(1))
+	assert.Equal(t, 66, slice.Length())
+
+	// 末尾已删除的位置一并去掉
+	p, ok := slice.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, 98, p)
+	p, _ = slice.Pop()
+	assert.Equal(t, 97, p)
+
+	// 重新设置已删除的位置，旧值是nil而不是墓碑
+	assert.Nil(t, slice.UpdateAt(3, 3))
+	assert.Equal(t, 3, slice.Load(3))
+	assert.Equal(t, 3, slice.UpdateAt(3, 3))
+
+	slice.Compact()
+	var want []interface{}
+	for i := 0; i < 97; i++ {
+		if i%3 != 0 || i == 3 {
+			want = append(want, i)
+		}
+	}
+	var got []interface{}
+	slice.Range(func(index int, p interface{}) (stopIteration bool) {
+		assert.Equal(t, len(got), index)
+		got = append(got, p)
+		return false
+	})
+	assert.Equal(t, want, got)
+	assert.Equal(t, len(want), slice.Append(-1))
+}
+
+func TestSlice_ConcurrentlyAppendAndPop(t *testing.T) {
+	// 每个值要么被Pop一次，要么留在Slice中；留下的值下标连续
+	var slice Slice
+
+	var wg sync.WaitGroup
+	var appendDone sync.WaitGroup
+	var popped sync.Map
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		appendDone.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer appendDone.Done()
+
+			for j := 0; j < 5000; j++ {
+				slice.Append(i*5000 + j)
+			}
+		}(i)
+	}
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if p, ok := slice.Pop(); ok {
+					if _, loaded := popped.LoadOrStore(p, true); loaded {
+						t.Errorf("%v popped twice", p)
+					}
+				}
+				if p, ok := slice.Pop(); ok {
+					slice.Append(p)
+				}
+			}
+		}()
+	}
+	appendDone.Wait()
+	close(stop)
+	wg.Wait()
+
+	seen := make(map[interface{}]bool)
+	popped.Range(func(key, value any) bool {
+		seen[key] = true
+		return true
+	})
+	var expectIndex int
+	slice.Range(func(index int, p interface{}) (stopIteration bool) {
+		assert.Equal(t, expectIndex, index)
+		expectIndex++
+		assert.False(t, seen[p], "%v both popped and remained", p)
+		seen[p] = true
+		return false
+	})
+	assert.Equal(t, 10*5000, len(seen))
+}
+
+func TestSlice_Reserve(t *testing.T) {
+	slice := NewSliceWithCapacity(100000)
+	store := slice.store.Load()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 10000; j++ {
+				index := slice.Append(j)
+				slice.UpdateAt(index, index)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 没有增长
+	assert.Same(t, store, slice.store.Load())
+	assert.Equal(t, 100000, slice.Length())
+	slice.Range(func(index int, p interface{}) (stopIteration bool) {
+		assert.Equal(t, index, p)
+		return false
+	})
+
+	// 预留后Pop，下标依然连续
+	var reserved Slice
+	reserved.Append(0)
+	reserved.Reserve(5000)
+	p, ok := reserved.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, 0, p)
+	for i := 0; i < 3000; i++ {
+		assert.Equal(t, i, reserved.Append(i))
+	}
+}
@@ -0,0 +1,121 @@
+package freesync
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// versionedWaiter 等待新版本的过程。无锁栈的节点。
+type versionedWaiter struct {
+	// ready 有新版本时关闭。
+	ready chan struct{}
+
+	// cancelled 等待者已经放弃。
+	cancelled atomic.Bool
+
+	next *versionedWaiter
+}
+
+// versionedState 一个版本的值。
+type versionedState[T any] struct {
+	value   T
+	version uint64
+
+	// waiters 等待比这个版本新的版本的过程。换成新版本后为sealed。
+	waiters atomic.Pointer[versionedWaiter]
+}
+
+// Versioned 带版本号的原子值。每次写入，版本号加1。
+// 读取不加锁；Wait等待比指定版本新的版本，等待者登记在当前版本的无锁栈中，写入新版本时全部唤醒。
+type Versioned[T any] struct {
+	state atomic.Pointer[versionedState[T]]
+
+	// sealed 等待者栈已封闭的标记。
+	sealed *versionedWaiter
+}
+
+// NewVersioned 新建一个带版本号的值，初始版本为0。
+func NewVersioned[T any](value T) *Versioned[T] {
+	versioned := &Versioned[T]{
+		sealed: &versionedWaiter{},
+	}
+	versioned.state.Store(&versionedState[T]{value: value})
+	return versioned
+}
+
+// Load 返回当前的值和版本。
+func (versioned *Versioned[T]) Load() (value T, version uint64) {
+	state := versioned.state.Load()
+	return state.value, state.version
+}
+
+// Store 写入新的值，返回新的版本。
+func (versioned *Versioned[T]) Store(value T) uint64 {
+	for {
+		old := versioned.state.Load()
+		if version, swapped := versioned.CompareAndSwapVersion(old.version, value); swapped {
+			return version
+		}
+	}
+}
+
+// CompareAndSwapVersion 当前版本为version时，写入新的值，返回新的版本；否则返回当前版本，swapped为false。
+func (versioned *Versioned[T]) CompareAndSwapVersion(version uint64, value T) (newVersion uint64, swapped bool) {
+	old := versioned.state.Load()
+	if old.version != version {
+		return old.version, false
+	}
+	state := &versionedState[T]{value: value, version: version + 1}
+	if !versioned.state.CompareAndSwap(old, state) {
+		return versioned.state.Load().version, false
+	}
+
+	// 唤醒等待旧版本变化的过程
+	for waiter := old.waiters.Swap(versioned.sealed); waiter != nil; waiter = waiter.next {
+		close(waiter.ready)
+	}
+	return state.version, true
+}
+
+// Wait 等待比sinceVersion新的版本，返回它的值和版本。已经有新版本时，立即返回。
+// ctx结束时，返回ctx.Err()。
+func (versioned *Versioned[T]) Wait(ctx context.Context, sinceVersion uint64) (value T, version uint64, err error) {
+	for {
+		state := versioned.state.Load()
+		if state.version > sinceVersion {
+			return state.value, state.version, nil
+		}
+
+		waiter := &versionedWaiter{ready: make(chan struct{})}
+		if !versioned.register(state, waiter) {
+			// 已经换成新版本
+			continue
+		}
+
+		select {
+		case <-waiter.ready:
+		case <-ctx.Done():
+			waiter.cancelled.Store(true)
+			return value, 0, ctx.Err()
+		}
+	}
+}
+
+// register 把等待者登记到state上。state已经不是当前版本时，返回false。
+// 顺便移除栈顶已经放弃的等待者，反复超时的等待不会让栈无限增长。
+func (versioned *Versioned[T]) register(state *versionedState[T], waiter *versionedWaiter) bool {
+	for {
+		head := state.waiters.Load()
+		if head == versioned.sealed {
+			return false
+		}
+		if head != nil && head.cancelled.Load() {
+			state.waiters.CompareAndSwap(head, head.next)
+			continue
+		}
+		waiter.next = head
+		if state.waiters.CompareAndSwap(head, waiter) {
+			return true
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package freesync
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersioned(t *testing.T) {
+	versioned := NewVersioned("a")
+	value, version := versioned.Load()
+	assert.Equal(t, "a", value)
+	assert.Equal(t, uint64(0), version)
+
+	assert.Equal(t, uint64(1), versioned.Store("b"))
+
+	// 版本不对
+	version, swapped := versioned.CompareAndSwapVersion(0, "c")
+	assert.False(t, swapped)
+	assert.Equal(t, uint64(1), version)
+
+	version, swapped = versioned.CompareAndSwapVersion(1, "c")
+	assert.True(t, swapped)
+	assert.Equal(t, uint64(2), version)
+	value, version = versioned.Load()
+	assert.Equal(t, "c", value)
+	assert.Equal(t, uint64(2), version)
+}
+
+func TestVersioned_Wait(t *testing.T) {
+	versioned := NewVersioned(0)
+
+	// 已经有新版本
+	versioned.Store(1)
+	value, version, err := versioned.Wait(context.Background(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+	assert.Equal(t, uint64(1), version)
+
+	// 超时
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = versioned.Wait(ctx, 1)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// 全部等待者被唤醒
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, version, err := versioned.Wait(context.Background(), 1)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, value)
+			assert.Equal(t, uint64(2), version)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	versioned.Store(2)
+	wg.Wait()
+}
+
+func TestVersioned_CancelledWaiters(t *testing.T) {
+	versioned := NewVersioned(0)
+
+	// 反复超时的等待不会积累
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, _, err := versioned.Wait(ctx, 0)
+		assert.Equal(t, context.Canceled, err)
+	}
+	var waiters int
+	for waiter := versioned.state.Load().waiters.Load(); waiter != nil; waiter = waiter.next {
+		waiters++
+	}
+	assert.Equal(t, 1, waiters)
+}
+
+func TestVersioned_Concurrently(t *testing.T) {
+	versioned := NewVersioned(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				for {
+					value, version := versioned.Load()
+					if _, swapped := versioned.CompareAndSwapVersion(version, value+1); swapped {
+						break
+					}
+				}
+			}
+		}()
+	}
+
+	// 观察者看到的版本单调递增，值与版本一致
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		var since uint64
+		for since < 1000 {
+			value, version, err := versioned.Wait(context.Background(), since)
+			assert.NoError(t, err)
+			assert.Greater(t, version, since)
+			assert.Equal(t, int(version), value)
+			since = version
+		}
+	}()
+	wg.Wait()
+}